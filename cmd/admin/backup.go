@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// backup writes every global table and every year to a zip archive:
+//
+//	admin backup [-o=collegecm_<time>.zip]
+//
+// The archive is written under a temporary name next to the output and renamed once
+// complete, so an interrupted backup never looks like a finished one.
+func (app *application) backup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	output := fs.String("o", "collegecm_"+time.Now().Format("20060102T150405")+".zip", "file to write the backup to")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(*output)
+	if err == nil {
+		return fmt.Errorf("backup: %s already exists", *output)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(*output), filepath.Base(*output)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	manifest, err := app.models.Backups.Dump(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), *output)
+	if err != nil {
+		return err
+	}
+	for _, table := range manifest.Tables {
+		app.logger.Printf("backed up %s: %d rows", table.Name, table.Rows)
+	}
+	for _, year := range manifest.Years {
+		rows := 0
+		for _, table := range year.Tables {
+			rows += table.Rows
+		}
+		app.logger.Printf("backed up %s: %d rows", year.Year, rows)
+	}
+	app.logger.Printf("backup written to %s", *output)
+	return nil
+}
+
+// restore loads a backup made by the backup command into an empty database:
+//
+//	admin restore <file>
+//
+// The schema is created by the migrations built into this binary, so the backup may
+// come from an older version.
+func (app *application) restore(args []string) error {
+	if len(args) != 1 {
+		return errors.New("restore: expected the backup file")
+	}
+	zr, err := zip.OpenReader(args[0])
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	manifest, err := app.models.Backups.Restore(&zr.Reader)
+	if err != nil {
+		return err
+	}
+	app.logger.Printf("restored %d tables and %d years from the backup of %s", len(manifest.Tables), len(manifest.Years), manifest.CreatedAt.Format("2006-01-02 15:04:05"))
+	return nil
+}
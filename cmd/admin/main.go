@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"collegecm.hamid.net/internal/data"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+// The admin binary holds one-off maintenance commands that run directly against the
+// database, outside of the HTTP API. Each command is selected by the first non-flag
+// argument, e.g. `admin -db-dsn=... rehash-passwords`.
+type config struct {
+	db struct {
+		dsn string
+	}
+}
+
+type application struct {
+	config config
+	logger *log.Logger
+	models data.Models
+}
+
+type command struct {
+	name        string
+	description string
+	run         func(app *application, args []string) error
+}
+
+var commands = []command{
+	{"rehash-passwords", "hash every user password that is still stored as plaintext", (*application).rehashPasswords},
+	{"migrate", "apply (up), revert (down) or list (status) schema migrations", (*application).migrate},
+	{"backup", "write every table to a zip archive (-o file)", (*application).backup},
+	{"restore", "load a backup archive into an empty database", (*application).restore},
+}
+
+func main() {
+	// the .env file is optional here, the DSN can also come from the flag.
+	_ = godotenv.Load()
+	var cfg config
+	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DATABASE_DSN"), "PostgreSQL DSN")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	db, err := openDB(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer db.Close()
+	app := &application{
+		config: cfg,
+		logger: logger,
+		models: data.NewModels(db),
+	}
+	err = cmd.run(app, flag.Args()[1:])
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: admin [flags] <command> [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func openDB(cfg config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.db.dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = db.PingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"collegecm.hamid.net/internal/data"
+)
+
+// migrate applies or reverts the embedded schema migrations:
+//
+//	admin migrate up
+//	admin migrate down [-scope=global|year] [-steps=1]
+//	admin migrate status
+func (app *application) migrate(args []string) error {
+	if len(args) < 1 {
+		return errors.New("migrate: expected up, down or status")
+	}
+	switch args[0] {
+	case "up":
+		applied, err := app.models.Migrations.Up()
+		for _, status := range applied {
+			app.logger.Printf("applied %s %06d_%s", status.Scope, status.Version, status.Name)
+		}
+		if err != nil {
+			return err
+		}
+		app.logger.Printf("%d migrations applied", len(applied))
+		return nil
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		scope := fs.String("scope", data.GlobalScope, "which migrations to revert (global|year)")
+		steps := fs.Int("steps", 1, "number of migrations to revert")
+		err := fs.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if *scope != data.GlobalScope && *scope != "year" {
+			return fmt.Errorf("migrate down: invalid scope %q", *scope)
+		}
+		if *steps < 1 {
+			return errors.New("migrate down: steps must be at least 1")
+		}
+		reverted, err := app.models.Migrations.Down(*scope, *steps)
+		for _, status := range reverted {
+			app.logger.Printf("reverted %s %06d_%s", status.Scope, status.Version, status.Name)
+		}
+		if err != nil {
+			return err
+		}
+		app.logger.Printf("%d migrations reverted", len(reverted))
+		return nil
+	case "status":
+		statuses, err := app.models.Migrations.Status()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SCOPE\tVERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%s\t%06d\t%s\t%s\n", status.Scope, status.Version, status.Name, applied)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("migrate: unknown action %q", args[0])
+	}
+}
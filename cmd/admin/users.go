@@ -0,0 +1,36 @@
+package main
+
+import "fmt"
+
+// rehashPasswords upgrades every user whose password is still stored as plaintext.
+// Users are also upgraded on their next successful login, this command exists so the
+// whole table can be cleaned up in one go. A user that can't be upgraded, e.g. because
+// bcrypt rejects a password over 72 bytes, is logged and skipped.
+func (app *application) rehashPasswords(args []string) error {
+	users, err := app.models.Users.GetAll()
+	if err != nil {
+		return err
+	}
+	count, failed := 0, 0
+	for _, user := range users {
+		if !user.NeedsRehash() {
+			continue
+		}
+		err = user.SetPassword(user.Password)
+		if err == nil {
+			err = app.models.Users.UpdatePassword(user)
+		}
+		if err != nil {
+			app.logger.Printf("can't rehash password for user %q: %v", user.Username, err)
+			failed++
+			continue
+		}
+		app.logger.Printf("rehashed password for user %q", user.Username)
+		count++
+	}
+	app.logger.Printf("%d of %d passwords rehashed", count, len(users))
+	if failed > 0 {
+		return fmt.Errorf("%d passwords could not be rehashed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/validator"
+)
+
+// archiveFile is a year archive in the archive directory.
+type archiveFile struct {
+	File     string                `json:"file"`
+	Size     int64                 `json:"size"`
+	Manifest *data.ArchiveManifest `json:"manifest"`
+}
+
+// archiveYear makes a year read-only. With "export" set its tables are also written
+// to a zip archive in the archive directory, which restoreYear can bring back.
+func (app *application) archiveYear(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Year   string `json:"year"`
+		Export bool   `json:"export"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	year := &data.Year{Year: input.Year}
+	v := validator.New()
+	if data.ValidateYear(v, year); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	wasArchived, err := app.models.Years.IsArchived(year.Year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// the year is made read-only first, so the export holds its final state.
+	err = app.models.Years.SetArchived(year.Year, true)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	env := envelope{"year": year.Year}
+	if input.Export {
+		archive, err := app.writeArchive(year.Year)
+		if err != nil {
+			if !wasArchived {
+				_ = app.models.Years.SetArchived(year.Year, false)
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["archive"] = archive
+	}
+	if !wasArchived {
+		app.audit(r, year.Year, "years", 0, data.AuditUpdate, envelope{"archived": false}, env)
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unarchiveYear makes an archived year writable again.
+func (app *application) unarchiveYear(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Year string `json:"year"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	year := &data.Year{Year: input.Year}
+	v := validator.New()
+	if data.ValidateYear(v, year); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	wasArchived, err := app.models.Years.IsArchived(year.Year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Years.SetArchived(year.Year, false)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if wasArchived {
+		app.audit(r, year.Year, "years", 0, data.AuditUpdate, envelope{"archived": true}, envelope{"archived": false})
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"year": year.Year}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getArchives lists the archives in the archive directory, newest first.
+func (app *application) getArchives(w http.ResponseWriter, r *http.Request) {
+	entries, err := os.ReadDir(app.config.archives.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	archives := []*archiveFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".zip" {
+			continue
+		}
+		archive, err := app.readArchive(entry.Name())
+		if err != nil {
+			app.logger.Printf("archive %s: %v", entry.Name(), err)
+			continue
+		}
+		archives = append(archives, archive)
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Manifest.CreatedAt.After(archives[j].Manifest.CreatedAt)
+	})
+	err = app.writeJSON(w, http.StatusOK, envelope{"archives": archives}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreYear recreates a year from an archive in the archive directory. The year must
+// not exist, and comes back archived.
+func (app *application) restoreYear(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		File string `json:"file"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.File != "", "الملف", "يجب ادخال اسم ملف الارشيف")
+	v.Check(filepath.Base(input.File) == input.File && filepath.Ext(input.File) == ".zip", "الملف", "اسم ملف غير صحيح")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	zr, err := zip.OpenReader(filepath.Join(app.config.archives.dir, input.File))
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, zip.ErrFormat):
+			v.AddError("الملف", "ملف الارشيف غير صالح")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer zr.Close()
+	manifest, err := app.models.Archives.Restore(&zr.Reader)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidArchive):
+			v.AddError("الملف", "ملف الارشيف غير صالح")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrYearExists):
+			v.AddError("السنة الاكاديمية", "السنة الاكاديمية موجودة مسبقاً")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.audit(r, manifest.Year, "years", 0, data.AuditCreate, nil, envelope{"restored_from": input.File})
+	err = app.writeJSON(w, http.StatusCreated, envelope{"restored": manifest}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writeArchive dumps the year into a new archive named <year>_<time>.zip. The archive
+// is written under a temporary name and renamed once complete.
+func (app *application) writeArchive(year string) (*archiveFile, error) {
+	err := os.MkdirAll(app.config.archives.dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(app.config.archives.dir, year+"_*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	manifest, err := app.models.Archives.Dump(tmp, year)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := year + "_" + manifest.CreatedAt.Format("20060102T150405") + ".zip"
+	err = os.Rename(tmp.Name(), filepath.Join(app.config.archives.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return app.readArchive(name)
+}
+
+func (app *application) readArchive(name string) (*archiveFile, error) {
+	zr, err := zip.OpenReader(filepath.Join(app.config.archives.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	manifest, err := data.ReadManifest(&zr.Reader)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filepath.Join(app.config.archives.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return &archiveFile{File: name, Size: info.Size(), Manifest: manifest}, nil
+}
+
+// deleteConfirmation is how long a year delete confirmation token stays valid.
+const deleteConfirmation = 5 * time.Minute
+
+// confirmYearDelete checks the confirmation token of a hard delete. Without a token a
+// new one is issued for the year and kept in the session, and the response asks for
+// it; the token is good for one delete of that year by the same session. It reports
+// whether the delete may go ahead, otherwise the response has been sent.
+func (app *application) confirmYearDelete(w http.ResponseWriter, r *http.Request, year, token string) bool {
+	ctx := r.Context()
+	if token == "" {
+		token, err := generateToken()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		expiry := time.Now().Add(deleteConfirmation)
+		app.sessionManager.Put(ctx, "deleteYear", year)
+		app.sessionManager.Put(ctx, "deleteYearToken", token)
+		app.sessionManager.Put(ctx, "deleteYearExpiry", expiry)
+		env := envelope{
+			"error":              "حذف السنة الاكاديمية نهائي، اعد الطلب مع رمز التأكيد لتنفيذه",
+			"confirmation_token": token,
+			"expires_at":         expiry,
+		}
+		err = app.writeJSON(w, http.StatusPreconditionRequired, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+	valid := app.sessionManager.GetString(ctx, "deleteYear") == year &&
+		tokensEqual(app.sessionManager.GetString(ctx, "deleteYearToken"), token) &&
+		time.Now().Before(app.sessionManager.GetTime(ctx, "deleteYearExpiry"))
+	if !valid {
+		v := validator.New()
+		v.AddError("confirmation_token", "رمز التأكيد غير صحيح او منتهي الصلاحية")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	for _, key := range []string{"deleteYear", "deleteYearToken", "deleteYearExpiry"} {
+		app.sessionManager.Remove(ctx, key)
+	}
+	return true
+}
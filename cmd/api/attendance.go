@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/validator"
+)
+
+// attendanceStatuses maps the status query parameter to the attendance statuses.
+var attendanceStatuses = map[string]string{
+	"warning": data.AttendanceWarning,
+	"ban":     data.AttendanceBan,
+}
+
+// getAttendance lists the absence percentage of every student in every subject of the
+// stage. "subject_id" and "student_id" narrow the list down, "status=warning|ban"
+// keeps the students with that status and "flagged=true" those with either.
+func (app *application) getAttendance(w http.ResponseWriter, r *http.Request) {
+	year, err := app.getYearFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	stage, err := app.getStageFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	qs := r.URL.Query()
+	v := validator.New()
+	filters := data.AttendanceFilters{
+		SubjectId: int64(app.readInt(qs, "subject_id", 0, v)),
+		StudentId: int64(app.readInt(qs, "student_id", 0, v)),
+		Flagged:   app.readString(qs, "flagged", "") == "true",
+	}
+	if status := app.readString(qs, "status", ""); status != "" {
+		filters.Status = attendanceStatuses[status]
+		v.Check(filters.Status != "", "status", "يجب ان تكون warning او ban")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	attendance, err := app.models.Attendance.Summaries(year, stage, app.getSubjectAccessFromContext(r), app.attendancePolicy(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"attendance": attendance}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// recordAttendance records a lecture of a subject with the ids of the students who
+// missed it in "absent"; every other student taking the subject is marked present.
+// Posting the same lecture again corrects it. Each student row created or changed is
+// audited on its own.
+func (app *application) recordAttendance(w http.ResponseWriter, r *http.Request) {
+	year, err := app.readYearParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		SubjectId int64   `json:"subject_id"`
+		Date      string  `json:"date"`
+		Period    *int    `json:"period"`
+		Absent    []int64 `json:"absent"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	session := &data.AttendanceSession{
+		SubjectId: input.SubjectId,
+		Date:      input.Date,
+		Period:    1,
+		Absent:    input.Absent,
+	}
+	if input.Period != nil {
+		session.Period = *input.Period
+	}
+	v := validator.New()
+	if data.ValidateAttendanceSession(v, session); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !app.attendanceWritable(w, r, year, session.SubjectId) {
+		return
+	}
+	changes, err := app.models.Attendance.RecordSession(year, session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrNotEnrolled):
+			v.AddError("الغياب", "يوجد طالب غير مسجل في المادة")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, change := range changes {
+		if change.Before == nil {
+			app.audit(r, year, "attendance", change.After.Id, data.AuditCreate, nil, change.After)
+		} else {
+			app.audit(r, year, "attendance", change.After.Id, data.AuditUpdate, change.Before, change.After)
+		}
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"session": session}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAttendance removes a lecture recorded by mistake.
+func (app *application) deleteAttendance(w http.ResponseWriter, r *http.Request) {
+	year, err := app.readYearParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		SubjectId int64  `json:"subject_id"`
+		Date      string `json:"date"`
+		Period    *int   `json:"period"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	session := &data.AttendanceSession{SubjectId: input.SubjectId, Date: input.Date, Period: 1}
+	if input.Period != nil {
+		session.Period = *input.Period
+	}
+	v := validator.New()
+	if data.ValidateAttendanceSession(v, session); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !app.attendanceWritable(w, r, year, session.SubjectId) {
+		return
+	}
+	records, err := app.models.Attendance.DeleteSession(year, session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, record := range records {
+		app.audit(r, year, "attendance", record.Id, data.AuditDelete, record, nil)
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم حذف المحاضرة"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// attendanceWritable reports whether the user may record attendance of the subject.
+// When they may not, or the check fails, the response has already been sent.
+func (app *application) attendanceWritable(w http.ResponseWriter, r *http.Request, year string, subjectId int64) bool {
+	subject, err := app.models.Subjects.Get(year, subjectId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+	user, err := app.getUserFromContext(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	hasAccess, err := app.models.Privileges.CheckSubjectWriteAccess(int(user.ID), "attendance_"+year, subject.Stage, subjectId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !hasAccess {
+		app.unauthorized(w, r)
+		return false
+	}
+	return true
+}
+
+// attendanceBanMessage rejects a final exam mark for a student barred by attendanceBanned.
+const attendanceBanMessage = "الطالب محروم من الامتحان النهائي بسبب الغياب"
+
+// attendanceBanned reports whether the student is barred from the subject's final
+// exam for missing too many lectures.
+func (app *application) attendanceBanned(year string, studentId, subjectId int64) (bool, error) {
+	status, err := app.models.Attendance.Status(year, studentId, subjectId, app.attendancePolicy())
+	if err != nil {
+		return false, err
+	}
+	return status == data.AttendanceBan, nil
+}
+
+// attendanceBans returns the student and subject ids of every pair barred by
+// attendanceBanned in the year, for checking a batch of marks with one query.
+func (app *application) attendanceBans(year string) (map[[2]int64]bool, error) {
+	summaries, err := app.models.Attendance.Summaries(year, "all", nil, app.attendancePolicy(), data.AttendanceFilters{Status: data.AttendanceBan})
+	if err != nil {
+		return nil, err
+	}
+	bans := make(map[[2]int64]bool, len(summaries))
+	for _, summary := range summaries {
+		bans[[2]int64{summary.StudentId, summary.SubjectId}] = true
+	}
+	return bans, nil
+}
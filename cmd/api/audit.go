@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+
+	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/validator"
+)
+
+// getAuditLog lists audit entries, newest first. It accepts the optional filters
+// user_id, year, table, record_id, action, from and to (dates, both inclusive) and a
+// limit of at most 1000 entries.
+func (app *application) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+	filters := data.AuditFilters{
+		UserId:    int64(app.readInt(qs, "user_id", 0, v)),
+		Year:      app.readString(qs, "year", ""),
+		TableName: app.readString(qs, "table", ""),
+		RecordId:  int64(app.readInt(qs, "record_id", 0, v)),
+		Action:    app.readString(qs, "action", ""),
+		From:      app.readDate(qs, "from", v),
+		To:        app.readDate(qs, "to", v),
+		Limit:     app.readInt(qs, "limit", 100, v),
+	}
+	if filters.Action != "" {
+		v.Check(validator.In(filters.Action, data.AuditCreate, data.AuditUpdate, data.AuditDelete), "action", "قيمة غير صحيحة")
+	}
+	if !filters.To.IsZero() {
+		// include the whole of the last day.
+		filters.To = filters.To.AddDate(0, 0, 1)
+	}
+	v.Check(filters.From.IsZero() || filters.To.IsZero() || filters.From.Before(filters.To), "from", "يجب ان يكون قبل to")
+	v.Check(filters.Limit > 0 && filters.Limit <= 1000, "limit", "يجب ان يكون بين 1 و 1000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	entries, err := app.models.Audit.GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -32,10 +32,26 @@ func (app *application) login(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	if user.Password != input.Password {
+	match, err := user.PasswordMatches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
 		app.badRequestResponse(w, r, errors.New("invalid username or password"))
 		return
 	}
+	// upgrade a legacy plaintext password now that we know it's correct. a failure
+	// here shouldn't lock the user out, the row will be retried on the next login.
+	if user.NeedsRehash() {
+		err = user.SetPassword(input.Password)
+		if err == nil {
+			err = app.models.Users.UpdatePassword(user)
+		}
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
 	err = app.sessionManager.RenewToken(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+
+	"collegecm.hamid.net/internal/data"
+)
+
+func (app *application) getAverages(w http.ResponseWriter, r *http.Request) {
+	year, err := app.getYearFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	stage, err := app.getStageFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	averages, err := app.models.Averages.GetAll(year, stage, app.gradePolicy())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if averages == nil {
+		averages = []*data.StudentAverage{}
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"averages": averages}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -19,7 +19,13 @@ func (app *application) getCarryovers(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
-	carryovers, err := app.models.Carryovers.GetAll(year, stage)
+	v := validator.New()
+	filters := app.readFilters(r, v, "id", "student_name", "subject_name")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	carryovers, metadata, err := app.models.Carryovers.GetAll(year, stage, filters)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -29,7 +35,11 @@ func (app *application) getCarryovers(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"carryovers": carryovers}, nil)
+	if app.exportRequested(r) {
+		app.export(w, r, "carryovers", carryoverHeaders, carryoverRows(carryovers))
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"carryovers": carryovers, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -175,6 +185,9 @@ func (app *application) createCarryover(w http.ResponseWriter, r *http.Request)
 		app.unauthorized(w, r)
 		return
 	}
+	if app.subjectLocked(w, r, year, input.SubjectId) {
+		return
+	}
 
 	carryover := &data.Carryover{
 		StudentId: input.StudentId,
@@ -202,6 +215,7 @@ func (app *application) createCarryover(w http.ResponseWriter, r *http.Request)
 	}
 	carryover.StudentName = student.StudentName
 	carryover.SubjectName = subject.SubjectName
+	app.audit(r, year, "carryovers", carryover.Id, data.AuditCreate, nil, carryover)
 	err = app.writeJSON(w, http.StatusCreated, envelope{"carryover": carryover}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -219,6 +233,19 @@ func (app *application) deleteCarryover(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
+	carryover, err := app.models.Carryovers.Get(year, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if app.subjectLocked(w, r, year, carryover.SubjectId) {
+		return
+	}
 	err = app.models.Carryovers.Delete(year, id)
 	if err != nil {
 		switch {
@@ -229,6 +256,7 @@ func (app *application) deleteCarryover(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	app.audit(r, year, "carryovers", id, data.AuditDelete, carryover, nil)
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم الحذف بنجاح"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -39,6 +39,7 @@ func (app *application) getStudentData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	studentData.Student = student
+	app.gradePolicy().GradeAll(studentData.Marks)
 	err = app.writeJSON(w, http.StatusOK, envelope{"student_data": studentData}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
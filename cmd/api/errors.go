@@ -70,3 +70,13 @@ func (app *application) unauthorized(w http.ResponseWriter, r *http.Request) {
 	message := "المستخدم غير مصرح له بالوصول إلى هذا المورد"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
+
+func (app *application) lockedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "النتائج مصادق عليها من اللجنة الامتحانية ولا يمكن تعديلها"
+	app.errorResponse(w, r, http.StatusLocked, message)
+}
+
+func (app *application) archivedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "السنة الاكاديمية مؤرشفة ولا يمكن تعديلها"
+	app.errorResponse(w, r, http.StatusLocked, message)
+}
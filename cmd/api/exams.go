@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/validator"
+)
+
+// getExams returns the exam timetable of the stage, optionally of one ?semester=. With
+// ?format=xlsx or csv it's sent as a file.
+func (app *application) getExams(w http.ResponseWriter, r *http.Request) {
+	year, err := app.getYearFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	stage, err := app.getStageFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	semester := strings.TrimSpace(app.readString(r.URL.Query(), "semester", ""))
+	exams, err := app.models.Exams.GetAll(year, stage, semester)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.exportRequested(r) {
+		app.export(w, r, "exams", examHeaders, examRows(exams))
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"exams": exams}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getExamConflicts lists the conflicts in the timetable involving exams of the stage.
+func (app *application) getExamConflicts(w http.ResponseWriter, r *http.Request) {
+	year, err := app.getYearFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	stage, err := app.getStageFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	conflicts, err := app.models.Exams.Conflicts(year, stage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"conflicts": conflicts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createExam schedules the final exam of a subject. The semester defaults to the
+// subject's. An exam clashing with another of the same stage or room is refused with
+// the conflicts; carryover conflicts are returned alongside the exam.
+func (app *application) createExam(w http.ResponseWriter, r *http.Request) {
+	year, err := app.readYearParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		SubjectId int64  `json:"subject_id"`
+		Semester  string `json:"semester"`
+		Date      string `json:"date"`
+		StartTime string `json:"start_time"`
+		EndTime   string `json:"end_time"`
+		Room      string `json:"room"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	subject, err := app.models.Subjects.Get(year, input.SubjectId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// privilege check
+	user, err := app.getUserFromContext(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	hasAccess, err := app.models.Privileges.CheckWriteAccess(int(user.ID), "exams_"+year, subject.Stage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !hasAccess {
+		app.unauthorized(w, r)
+		return
+	}
+	exam := &data.Exam{
+		SubjectId: input.SubjectId,
+		Semester:  strings.TrimSpace(input.Semester),
+		Date:      input.Date,
+		StartTime: input.StartTime,
+		EndTime:   input.EndTime,
+		Room:      strings.TrimSpace(input.Room),
+	}
+	if exam.Semester == "" {
+		exam.Semester = subject.Semester
+	}
+	v := validator.New()
+	if data.ValidateExam(v, exam); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	conflicts, err := app.models.Exams.Insert(year, exam)
+	if err != nil {
+		app.examSaveError(w, r, err, conflicts)
+		return
+	}
+	app.audit(r, year, "exams", exam.ID, data.AuditCreate, nil, exam)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"exam": exam, "conflicts": conflicts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateExam moves an exam to another slot or room, with the same conflict rules as
+// createExam. The subject can't be changed.
+func (app *application) updateExam(w http.ResponseWriter, r *http.Request) {
+	year, err := app.getYearFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	exam, err := app.getExamFromContext(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	before := *exam
+	var input struct {
+		Semester  *string `json:"semester"`
+		Date      *string `json:"date"`
+		StartTime *string `json:"start_time"`
+		EndTime   *string `json:"end_time"`
+		Room      *string `json:"room"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Semester != nil {
+		exam.Semester = strings.TrimSpace(*input.Semester)
+	}
+	if input.Date != nil {
+		exam.Date = *input.Date
+	}
+	if input.StartTime != nil {
+		exam.StartTime = *input.StartTime
+	}
+	if input.EndTime != nil {
+		exam.EndTime = *input.EndTime
+	}
+	if input.Room != nil {
+		exam.Room = strings.TrimSpace(*input.Room)
+	}
+	v := validator.New()
+	if data.ValidateExam(v, exam); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	conflicts, err := app.models.Exams.Update(year, exam)
+	if err != nil {
+		app.examSaveError(w, r, err, conflicts)
+		return
+	}
+	app.audit(r, year, "exams", exam.ID, data.AuditUpdate, before, exam)
+	err = app.writeJSON(w, http.StatusOK, envelope{"exam": exam, "conflicts": conflicts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteExam(w http.ResponseWriter, r *http.Request) {
+	year, err := app.getYearFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	exam, err := app.getExamFromContext(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Exams.Delete(year, exam.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.audit(r, year, "exams", exam.ID, data.AuditDelete, exam, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم الحذف بنجاح"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// examSaveError sends the response for an exam that couldn't be saved. A conflicting
+// exam gets 409 Conflict with the conflicts it would cause.
+func (app *application) examSaveError(w http.ResponseWriter, r *http.Request, err error, conflicts []*data.ExamConflict) {
+	switch {
+	case errors.Is(err, data.ErrExamConflict):
+		env := envelope{
+			"error":     "يتعارض موعد الامتحان مع امتحان آخر للمرحلة نفسها او في القاعة نفسها",
+			"conflicts": conflicts,
+		}
+		err = app.writeJSON(w, http.StatusConflict, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	case errors.Is(err, data.ErrDuplicateExam):
+		app.failedValidationResponse(w, r, map[string]string{"المادة": "للمادة امتحان مجدول في هذا الفصل"})
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
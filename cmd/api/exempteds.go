@@ -19,7 +19,13 @@ func (app *application) getExempteds(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
-	exempteds, err := app.models.Exempteds.GetAll(year, stage)
+	v := validator.New()
+	filters := app.readFilters(r, v, "id", "student_name", "subject_name")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	exempteds, metadata, err := app.models.Exempteds.GetAll(year, stage, filters)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -29,7 +35,11 @@ func (app *application) getExempteds(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"exempteds": exempteds}, nil)
+	if app.exportRequested(r) {
+		app.export(w, r, "exempted", exemptedHeaders, exemptedRows(exempteds))
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"exempteds": exempteds, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -175,6 +185,9 @@ func (app *application) createExempted(w http.ResponseWriter, r *http.Request) {
 		app.unauthorized(w, r)
 		return
 	}
+	if app.subjectLocked(w, r, year, input.SubjectId) {
+		return
+	}
 
 	exempted := &data.Exempted{
 		StudentId: input.StudentId,
@@ -202,6 +215,7 @@ func (app *application) createExempted(w http.ResponseWriter, r *http.Request) {
 	}
 	exempted.StudentName = student.StudentName
 	exempted.SubjectName = subject.SubjectName
+	app.audit(r, year, "exempted", exempted.Id, data.AuditCreate, nil, exempted)
 	err = app.writeJSON(w, http.StatusCreated, envelope{"exempted": exempted}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -219,6 +233,19 @@ func (app *application) deleteExempted(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
+	exempted, err := app.models.Exempteds.Get(year, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if app.subjectLocked(w, r, year, exempted.SubjectId) {
+		return
+	}
 	err = app.models.Exempteds.Delete(year, id)
 	if err != nil {
 		switch {
@@ -229,6 +256,7 @@ func (app *application) deleteExempted(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	app.audit(r, year, "exempted", id, data.AuditDelete, exempted, nil)
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم الحذف بنجاح"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
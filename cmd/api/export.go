@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"collegecm.hamid.net/internal/data"
+	"github.com/xuri/excelize/v2"
+)
+
+// The formats a list can be exported in with ?format=.
+const (
+	formatXLSX = "xlsx"
+	formatCSV  = "csv"
+)
+
+// exportRequested reports whether the client asked for a file instead of JSON.
+func (app *application) exportRequested(r *http.Request) bool {
+	return r.URL.Query().Get("format") != ""
+}
+
+// export sends rows as an Excel workbook or a CSV file, depending on ?format=. The file
+// is named after name and the year and stage URL parameters.
+func (app *application) export(w http.ResponseWriter, r *http.Request, name string, headers []string, rows [][]interface{}) {
+	format := r.URL.Query().Get("format")
+	filename := name
+	for _, param := range []string{"year", "stage"} {
+		if value := r.PathValue(param); value != "" {
+			filename += "_" + value
+		}
+	}
+	var err error
+	switch format {
+	case formatXLSX:
+		err = app.writeXLSX(w, filename+".xlsx", name, headers, rows)
+	case formatCSV:
+		err = app.writeCSV(w, filename+".csv", headers, rows)
+	default:
+		app.failedValidationResponse(w, r, map[string]string{"format": "يجب ان يكون xlsx او csv"})
+		return
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writeXLSX streams a single right-to-left sheet with a bold header row.
+func (app *application) writeXLSX(w http.ResponseWriter, filename, sheet string, headers []string, rows [][]interface{}) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	err := f.SetSheetName(f.GetSheetName(0), sheet)
+	if err != nil {
+		return err
+	}
+	err = setRightToLeft(f, sheet)
+	if err != nil {
+		return err
+	}
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+	err = sw.SetColWidth(1, len(headers), 20)
+	if err != nil {
+		return err
+	}
+	header := make([]interface{}, len(headers))
+	for i, h := range headers {
+		header[i] = h
+	}
+	err = sw.SetRow("A1", header, excelize.RowOpts{StyleID: bold})
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		err = sw.SetRow(cell, row)
+		if err != nil {
+			return err
+		}
+	}
+	err = sw.Flush()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	return f.Write(w)
+}
+
+func setRightToLeft(f *excelize.File, sheet string) error {
+	rtl := true
+	return f.SetSheetView(sheet, 0, &excelize.ViewOptions{RightToLeft: &rtl})
+}
+
+// writeCSV writes the rows as UTF-8 CSV. The byte order mark makes Excel read the
+// Arabic text correctly.
+func (app *application) writeCSV(w http.ResponseWriter, filename string, headers []string, rows [][]interface{}) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	_, err := w.Write([]byte("\ufeff"))
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	err = cw.Write(headers)
+	if err != nil {
+		return err
+	}
+	record := make([]string, len(headers))
+	for _, row := range rows {
+		for i, value := range row {
+			record[i] = csvValue(value)
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "نعم"
+		}
+		return "كلا"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Arabic column headers and row builders of the exported lists.
+
+var studentHeaders = []string{"التسلسل", "رقم الطالب", "اسم الطالب", "المرحلة", "الحالة"}
+
+func studentRows(students []*data.Student) [][]interface{} {
+	rows := make([][]interface{}, 0, len(students))
+	for _, s := range students {
+		rows = append(rows, []interface{}{s.SeqInCollege, s.StudentId, s.StudentName, s.Stage, s.State})
+	}
+	return rows
+}
+
+var subjectHeaders = []string{
+	"رقم المادة", "اسم المادة", "الاسم باللغة الانكليزية", "المرحلة", "الفصل", "القسم",
+	"درجة النظري", "درجة العملي", "درجة السعي", "درجة الامتحان النهائي", "الوحدات", "فعالة", "وزارية",
+}
+
+func subjectRows(subjects []*data.Subject) [][]interface{} {
+	rows := make([][]interface{}, 0, len(subjects))
+	for _, s := range subjects {
+		rows = append(rows, []interface{}{
+			s.ID, s.SubjectName, s.SubjectNameEnglish, s.Stage, s.Semester, s.Department,
+			s.MaxTheoryMark, s.MaxLabMark, s.MaxSemesterMark, s.MaxFinalExam, s.Credits, s.Active, s.Ministerial,
+		})
+	}
+	return rows
+}
+
+var carryoverHeaders = []string{"ت", "اسم الطالب", "اسم المادة"}
+
+func carryoverRows(carryovers []*data.Carryover) [][]interface{} {
+	rows := make([][]interface{}, 0, len(carryovers))
+	for i, c := range carryovers {
+		rows = append(rows, []interface{}{i + 1, c.StudentName, c.SubjectName})
+	}
+	return rows
+}
+
+var exemptedHeaders = []string{"ت", "اسم الطالب", "اسم المادة"}
+
+func exemptedRows(exempteds []*data.Exempted) [][]interface{} {
+	rows := make([][]interface{}, 0, len(exempteds))
+	for i, e := range exempteds {
+		rows = append(rows, []interface{}{i + 1, e.StudentName, e.SubjectName})
+	}
+	return rows
+}
+
+var markHeaders = []string{
+	"اسم الطالب", "اسم المادة", "السعي", "درجة الامتحان النهائي", "درجة الدور الثاني",
+	"المجموع", "النسبة", "التقدير",
+}
+
+// markRows expects graded marks.
+func markRows(marks []*data.Mark) [][]interface{} {
+	rows := make([][]interface{}, 0, len(marks))
+	for _, m := range marks {
+		var second interface{}
+		if m.SecondFinalMark != nil {
+			second = *m.SecondFinalMark
+		}
+		rows = append(rows, []interface{}{
+			m.StudentName, m.SubjectName, m.SemesterMark, m.FinalMark, second,
+			m.Total, m.Percentage, m.Grade,
+		})
+	}
+	return rows
+}
+
+var examHeaders = []string{"التاريخ", "اليوم", "من", "الى", "المرحلة", "المادة", "الفصل", "القاعة"}
+
+// weekdayNames are the Arabic names of the days on the exam timetable.
+var weekdayNames = [...]string{"الاحد", "الاثنين", "الثلاثاء", "الاربعاء", "الخميس", "الجمعة", "السبت"}
+
+func examRows(exams []*data.Exam) [][]interface{} {
+	rows := make([][]interface{}, 0, len(exams))
+	for _, e := range exams {
+		var day string
+		if date, err := time.Parse(time.DateOnly, e.Date); err == nil {
+			day = weekdayNames[date.Weekday()]
+		}
+		rows = append(rows, []interface{}{e.Date, day, e.StartTime, e.EndTime, e.Stage, e.SubjectName, e.Semester, e.Room})
+	}
+	return rows
+}
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/validator"
 	"github.com/gocarina/gocsv"
 	"github.com/xuri/excelize/v2"
 )
@@ -228,7 +234,8 @@ func (app *application) readExcel(filePath string) ([][]string, error) {
 	}
 	defer f.Close()
 
-	rows, err := f.GetRows("Sheet1") // Assuming data is in Sheet1
+	// read the first sheet, whatever it's called (Sheet1, ورقة1, ...)
+	rows, err := f.GetRows(f.GetSheetName(0))
 	if err != nil {
 		return nil, err
 	}
@@ -243,6 +250,159 @@ func (app *application) readExcel(filePath string) ([][]string, error) {
 // 	return isLoggedIn
 // }
 
+// readString returns a string value from the query string, or the provided default
+// value if no matching key could be found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+// readInt reads a string value from the query string and converts it to an integer
+// before returning. If no matching key could be found it returns the provided default
+// value. If the value couldn't be converted to an integer, then we record an error
+// message in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "يجب ان يكون رقم صحيح")
+		return defaultValue
+	}
+	return i
+}
+
+// readDate reads a YYYY-MM-DD date from the query string. The zero time is returned
+// when the key is missing or invalid, an invalid value is recorded in the Validator.
+func (app *application) readDate(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		v.AddError(key, "يجب ان يكون تاريخ بصيغة YYYY-MM-DD")
+		return time.Time{}
+	}
+	return t
+}
+
+// readFilters reads the page, page_size, sort, name, state, department and semester
+// query parameters of a list endpoint and validates them. sort may be any of columns,
+// prefixed with "-" for descending order. Exports always get every row.
+func (app *application) readFilters(r *http.Request, v *validator.Validator, columns ...string) data.Filters {
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:       app.readInt(qs, "page", 1, v),
+		PageSize:   app.readInt(qs, "page_size", 0, v),
+		Sort:       app.readString(qs, "sort", ""),
+		Name:       strings.TrimSpace(app.readString(qs, "name", "")),
+		State:      app.readString(qs, "state", ""),
+		Department: app.readString(qs, "department", ""),
+		Semester:   app.readString(qs, "semester", ""),
+	}
+	for _, column := range columns {
+		filters.SortSafelist = append(filters.SortSafelist, column, "-"+column)
+	}
+	data.ValidateFilters(v, filters)
+	if app.exportRequested(r) {
+		filters.Page, filters.PageSize = 1, 0
+	}
+	return filters
+}
+
+// gradePolicy returns the grading rules configured for this instance.
+func (app *application) gradePolicy() data.GradePolicy {
+	return data.GradePolicy{
+		PassThreshold: app.config.grading.passThreshold,
+		SecondRound:   app.config.grading.secondRound,
+	}
+}
+
+// promotionRules returns the end-of-year promotion rules configured for this instance.
+func (app *application) promotionRules() data.PromotionRules {
+	return data.PromotionRules{
+		MaxCarryovers:  app.config.promotion.maxCarryovers,
+		FinalStage:     app.config.promotion.finalStage,
+		ExcludedStates: app.config.reports.excludedStates,
+	}
+}
+
+// attendancePolicy returns the absence thresholds configured for this instance.
+func (app *application) attendancePolicy() data.AttendancePolicy {
+	return data.AttendancePolicy{
+		WarningThreshold: app.config.attendance.warningThreshold,
+		BanThreshold:     app.config.attendance.banThreshold,
+	}
+}
+
+// audit records a write made by the current user in the audit log. It runs after the
+// write has been saved, so a failure is logged instead of failing the request.
+func (app *application) audit(r *http.Request, year, table string, recordId int64, action string, before, after interface{}) {
+	user, err := app.getUserFromContext(r)
+	if err != nil {
+		app.logger.Printf("audit %s %s %d: %v", action, table, recordId, err)
+		return
+	}
+	entry, err := data.NewAuditEntry(user.ID, year, table, recordId, action, before, after)
+	if err == nil {
+		err = app.models.Audit.Insert(entry)
+	}
+	if err != nil {
+		app.logger.Printf("audit %s %s %d: %v", action, table, recordId, err)
+	}
+}
+
+// subjectLocked reports whether the subject's results are locked. When they are, or
+// the check fails, the response has already been sent and the handler should return.
+func (app *application) subjectLocked(w http.ResponseWriter, r *http.Request, year string, subjectId int64) bool {
+	locked, err := app.models.Locks.IsSubjectLocked(year, subjectId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+	if locked {
+		app.lockedResponse(w, r)
+		return true
+	}
+	return false
+}
+
+// studentLocked is subjectLocked for deleting a student, see LockModel.IsStudentLocked.
+func (app *application) studentLocked(w http.ResponseWriter, r *http.Request, year string, studentId int64) bool {
+	locked, err := app.models.Locks.IsStudentLocked(year, studentId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+	if locked {
+		app.lockedResponse(w, r)
+		return true
+	}
+	return false
+}
+
+// canRead reports whether the current user may read the year's table for the stage.
+func (app *application) canRead(r *http.Request, table, year, stage string) (bool, error) {
+	user, err := app.getUserFromContext(r)
+	if err != nil {
+		return false, err
+	}
+	privilege, err := app.models.Privileges.CheckAccess(int(user.ID), table+"_"+year, stage)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return privilege.CanRead, nil
+}
+
 func (app *application) getUserFromContext(r *http.Request) (*data.User, error) {
 	user, ok := r.Context().Value(userModelContextKey).(*data.User)
 	if !ok {
@@ -299,6 +459,13 @@ func (app *application) getSubjectFromContext(r *http.Request) (*data.Subject, e
 	return subject, nil
 }
 
+// getSubjectAccessFromContext returns the subjects the user may read, or nil (every
+// subject) when the route doesn't scope access by subject.
+func (app *application) getSubjectAccessFromContext(r *http.Request) *data.SubjectAccess {
+	access, _ := r.Context().Value(subjectAccessContextKey).(*data.SubjectAccess)
+	return access
+}
+
 func (app *application) getMarkFromContext(r *http.Request) (*data.Mark, error) {
 	mark, ok := r.Context().Value(markContextKey).(*data.Mark)
 	if !ok {
@@ -307,6 +474,14 @@ func (app *application) getMarkFromContext(r *http.Request) (*data.Mark, error)
 	return mark, nil
 }
 
+func (app *application) getExamFromContext(r *http.Request) (*data.Exam, error) {
+	exam, ok := r.Context().Value(examContextKey).(*data.Exam)
+	if !ok {
+		return nil, errors.New("can't get exam from context")
+	}
+	return exam, nil
+}
+
 // get stages from context, an array of strings
 //func (app *application) getStagesFromContext(r *http.Request) ([]string, error) {
 //	stages, ok := r.Context().Value(stagesContextKey).([]string)
@@ -315,3 +490,18 @@ func (app *application) getMarkFromContext(r *http.Request) (*data.Mark, error)
 //	}
 //	return stages, nil
 //}
+
+// generateToken returns a random token for confirming destructive requests.
+func generateToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// tokensEqual compares two tokens in constant time. An empty token never matches.
+func tokensEqual(want, got string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/validator"
+)
+
+func (app *application) getLocks(w http.ResponseWriter, r *http.Request) {
+	year, err := app.readYearParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	locks, err := app.models.Locks.GetAll(year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"locks": locks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readLock reads the year, stage and optional subject_id of a lock request. The year
+// must exist. When a subject is given its stage is taken from the subject. It returns
+// nil when a response has already been sent.
+func (app *application) readLock(w http.ResponseWriter, r *http.Request) *data.Lock {
+	var input struct {
+		Year      string `json:"year"`
+		Stage     string `json:"stage"`
+		SubjectId *int64 `json:"subject_id"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil
+	}
+	lock := &data.Lock{
+		Year:      input.Year,
+		Stage:     input.Stage,
+		SubjectId: -1,
+	}
+	// the year names the tables the subject is looked up in, so it's checked first.
+	v := validator.New()
+	if data.ValidateYear(v, &data.Year{Year: lock.Year}); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+	exists, err := app.models.Years.Exists(lock.Year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+	if !exists {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	if input.SubjectId != nil && *input.SubjectId != -1 {
+		lock.SubjectId = *input.SubjectId
+		subject, err := app.models.Subjects.Get(lock.Year, lock.SubjectId)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("المادة", "المادة غير موجودة")
+			default:
+				app.serverErrorResponse(w, r, err)
+				return nil
+			}
+		} else {
+			v.Check(lock.Stage == "" || lock.Stage == subject.Stage, "المرحلة", "المادة لا تنتمي لهذه المرحلة")
+			lock.Stage = subject.Stage
+		}
+	}
+	if data.ValidateLock(v, lock); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+	return lock
+}
+
+// createLock freezes the results of a stage, or of a single subject, of a year.
+func (app *application) createLock(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromContext(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	lock := app.readLock(w, r)
+	if lock == nil {
+		return
+	}
+	lock.LockedBy = user.ID
+	created, err := app.models.Locks.Insert(lock)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if lock.LockedBy == user.ID {
+		lock.LockedName = user.Username
+	}
+	if created {
+		app.audit(r, lock.Year, "locks", lock.ID, data.AuditCreate, nil, lock)
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"lock": lock}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteLock(w http.ResponseWriter, r *http.Request) {
+	lock := app.readLock(w, r)
+	if lock == nil {
+		return
+	}
+	deleted, err := app.models.Locks.Delete(lock.Year, lock.Stage, lock.SubjectId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.audit(r, deleted.Year, "locks", deleted.ID, data.AuditDelete, deleted, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم فك القفل بنجاح"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
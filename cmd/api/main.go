@@ -8,9 +8,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/validator"
 	"github.com/alexedwards/scs/postgresstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/joho/godotenv"
@@ -36,6 +38,24 @@ type config struct {
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	grading struct {
+		passThreshold float64
+		secondRound   string
+	}
+	reports struct {
+		excludedStates []string
+	}
+	promotion struct {
+		maxCarryovers int
+		finalStage    int
+	}
+	archives struct {
+		dir string
+	}
+	attendance struct {
+		warningThreshold float64
+		banThreshold     float64
+	}
 }
 
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers,
@@ -66,7 +86,32 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "MySQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "MySQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "MySQL max connection idle time")
+	flag.Float64Var(&cfg.grading.passThreshold, "grade-pass-threshold", data.DefaultPassThreshold, "Percentage of a subject's full mark needed to pass")
+	flag.StringVar(&cfg.grading.secondRound, "grade-second-round", data.SecondRoundBest, "How second-round marks are combined (best|replace|capped)")
+	excludedStates := flag.String("ranking-excluded-states", data.StateWithdrawn+","+data.StatePostponed, "Comma separated student states left out of the class order")
+	flag.IntVar(&cfg.promotion.maxCarryovers, "promotion-max-carryovers", 2, "Most failed subjects a student can carry into the next stage")
+	flag.IntVar(&cfg.promotion.finalStage, "promotion-final-stage", len(data.Stages), "Number of the college's final stage")
+	flag.Float64Var(&cfg.attendance.warningThreshold, "attendance-warning-threshold", 10, "Absence percentage of a subject's lectures at which a student is warned")
+	flag.Float64Var(&cfg.attendance.banThreshold, "attendance-ban-threshold", 15, "Absence percentage of a subject's lectures at which a student is barred from its final exam")
+	flag.StringVar(&cfg.archives.dir, "archive-dir", "archives", "Directory the year archives are written to and restored from")
 	flag.Parse()
+	if cfg.promotion.finalStage < 1 || cfg.promotion.finalStage > len(data.Stages) {
+		log.Fatalf("promotion-final-stage must be between 1 and %d", len(data.Stages))
+	}
+	for _, state := range strings.Split(*excludedStates, ",") {
+		if state = strings.TrimSpace(state); state != "" {
+			cfg.reports.excludedStates = append(cfg.reports.excludedStates, state)
+		}
+	}
+	if cfg.grading.passThreshold <= 0 || cfg.grading.passThreshold > 100 {
+		log.Fatal("grade-pass-threshold must be between 0 and 100")
+	}
+	if cfg.attendance.warningThreshold <= 0 || cfg.attendance.warningThreshold > cfg.attendance.banThreshold || cfg.attendance.banThreshold > 100 {
+		log.Fatal("attendance thresholds must satisfy 0 < warning <= ban <= 100")
+	}
+	if !validator.In(cfg.grading.secondRound, data.SecondRoundPolicies...) {
+		log.Fatal("grade-second-round must be one of best, replace or capped")
+	}
 	// Initialize a new logger which writes messages to the standard out stream,
 	// prefixed with the current date and time.
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
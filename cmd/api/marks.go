@@ -1,8 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"collegecm.hamid.net/internal/data"
 	"collegecm.hamid.net/internal/validator"
@@ -19,7 +25,13 @@ func (app *application) getMarks(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
-	marks, err := app.models.Marks.GetAll(year, stage)
+	v := validator.New()
+	filters := app.readFilters(r, v, "id", "student_name", "subject_name", "semester_mark", "final_mark")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	marks, metadata, err := app.models.Marks.GetAll(year, stage, app.getSubjectAccessFromContext(r), filters)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -29,7 +41,45 @@ func (app *application) getMarks(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"marks": marks}, nil)
+	app.gradePolicy().GradeAll(marks)
+	if app.exportRequested(r) {
+		app.export(w, r, "marks", markHeaders, markRows(marks))
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"marks": marks, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getSecondRoundMarks lists the marks of a stage that are eligible for the second
+// round: the student failed the first round and isn't exempted from the subject.
+func (app *application) getSecondRoundMarks(w http.ResponseWriter, r *http.Request) {
+	year, err := app.getYearFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	stage, err := app.getStageFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	marks, err := app.models.Marks.GetSecondRound(year, stage, app.getSubjectAccessFromContext(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	policy := app.gradePolicy()
+	eligible := []*data.Mark{}
+	for _, mark := range marks {
+		if policy.PassedFirstRound(mark) {
+			continue
+		}
+		policy.Grade(mark)
+		eligible = append(eligible, mark)
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"marks": eligible}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -86,7 +136,7 @@ func (app *application) createMark(w http.ResponseWriter, r *http.Request) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	hasAccess, err := app.models.Privileges.CheckWriteAccess(int(user.ID), "marks_"+year, stage)
+	hasAccess, err := app.models.Privileges.CheckSubjectWriteAccess(int(user.ID), "marks_"+year, stage, input.SubjectId)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -95,6 +145,9 @@ func (app *application) createMark(w http.ResponseWriter, r *http.Request) {
 		app.unauthorized(w, r)
 		return
 	}
+	if app.subjectLocked(w, r, year, input.SubjectId) {
+		return
+	}
 
 	mark := &data.Mark{
 		StudentId: input.StudentId,
@@ -116,6 +169,14 @@ func (app *application) createMark(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	v := validator.New()
+	if mark.FinalMark > 0 {
+		banned, err := app.attendanceBanned(year, mark.StudentId, mark.SubjectId)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(!banned, "درجة الامتحان النهائي", attendanceBanMessage)
+	}
 	if data.ValidateMark(v, mark, subject.MaxSemesterMark, subject.MaxFinalExam); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -134,12 +195,16 @@ func (app *application) createMark(w http.ResponseWriter, r *http.Request) {
 	mark.SubjectName = subject.SubjectName
 	mark.MaxSemesterMark = subject.MaxSemesterMark
 	mark.MaxFinalExam = subject.MaxFinalExam
+	app.gradePolicy().Grade(mark)
+	app.audit(r, year, "marks", mark.Id, data.AuditCreate, nil, mark)
 	err = app.writeJSON(w, http.StatusCreated, envelope{"mark": mark}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// updateMark changes the semester, final or second round marks of a mark. A null
+// second_final_mark clears the second round mark.
 func (app *application) updateMark(w http.ResponseWriter, r *http.Request) {
 	year, err := app.getYearFromContext(r)
 	if err != nil {
@@ -156,15 +221,31 @@ func (app *application) updateMark(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if app.subjectLocked(w, r, year, mark.SubjectId) {
+		return
+	}
+	before := *mark
+	// second_final_mark is kept raw to tell an explicit null, which clears the second
+	// round mark, from a missing field.
 	var input struct {
-		SemesterMark *int `json:"semester_mark"`
-		FinalMark    *int `json:"final_mark"`
+		SemesterMark    *int            `json:"semester_mark"`
+		FinalMark       *int            `json:"final_mark"`
+		SecondFinalMark json.RawMessage `json:"second_final_mark"`
 	}
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	var secondFinalMark *int
+	clearSecondFinalMark := string(input.SecondFinalMark) == "null"
+	if len(input.SecondFinalMark) > 0 && !clearSecondFinalMark {
+		err = json.Unmarshal(input.SecondFinalMark, &secondFinalMark)
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("body contains incorrect JSON type for field %q", "second_final_mark"))
+			return
+		}
+	}
 	if input.SemesterMark != nil {
 		mark.SemesterMark = *input.SemesterMark
 	}
@@ -177,6 +258,31 @@ func (app *application) updateMark(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	v := validator.New()
+	if clearSecondFinalMark {
+		mark.SecondFinalMark = nil
+	}
+	if secondFinalMark != nil {
+		mark.SecondFinalMark = secondFinalMark
+		mark.MaxSemesterMark = subject.MaxSemesterMark
+		mark.MaxFinalExam = subject.MaxFinalExam
+		exempted, err := app.models.Exempteds.Exists(year, mark.StudentId, mark.SubjectId)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(!exempted, "درجة الدور الثاني", "الطالب معفى من هذه المادة")
+		v.Check(!app.gradePolicy().PassedFirstRound(mark), "درجة الدور الثاني", "الطالب ناجح في الدور الاول")
+	}
+	finalRaised := input.FinalMark != nil && *input.FinalMark > 0 ||
+		secondFinalMark != nil && *secondFinalMark > 0
+	if finalRaised {
+		banned, err := app.attendanceBanned(year, mark.StudentId, mark.SubjectId)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(!banned, "درجة الامتحان النهائي", attendanceBanMessage)
+	}
 	if data.ValidateMark(v, mark, subject.MaxSemesterMark, subject.MaxFinalExam); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -191,6 +297,8 @@ func (app *application) updateMark(w http.ResponseWriter, r *http.Request) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.gradePolicy().Grade(newMark)
+	app.audit(r, year, "marks", mark.Id, data.AuditUpdate, before, newMark)
 	err = app.writeJSON(w, http.StatusCreated, envelope{"mark": newMark}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -208,6 +316,14 @@ func (app *application) deleteMark(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
+	mark, err := app.getMarkFromContext(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.subjectLocked(w, r, year, mark.SubjectId) {
+		return
+	}
 	err = app.models.Marks.Delete(year, id)
 	if err != nil {
 		switch {
@@ -218,8 +334,336 @@ func (app *application) deleteMark(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	app.audit(r, year, "marks", id, data.AuditDelete, mark, nil)
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم الحذف بنجاح"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The status of one row of a bulk mark write.
+const (
+	markCreated = "created"
+	markUpdated = "updated"
+	markFailed  = "failed"
+)
+
+// maxMarkRows caps the number of marks accepted by a single bulk write.
+const maxMarkRows = 2000
+
+// markRow is one mark of a bulk write, as sent by the client or read from a sheet.
+type markRow struct {
+	StudentId    int64 `json:"student_id"`
+	SubjectId    int64 `json:"subject_id"`
+	SemesterMark *int  `json:"semester_mark"`
+	FinalMark    *int  `json:"final_mark"`
+}
+
+// markResult reports what happened to one row of a bulk write. Row counts from 1.
+type markResult struct {
+	Row       int               `json:"row"`
+	StudentId int64             `json:"student_id"`
+	SubjectId int64             `json:"subject_id"`
+	Status    string            `json:"status"`
+	Errors    map[string]string `json:"errors,omitempty"`
+	Mark      *data.Mark        `json:"mark,omitempty"`
+}
+
+// writeMarks validates every row and upserts the valid ones in one transaction with
+// their audit entries. A row fails when its student or subject doesn't exist, when the
+// user can't write marks of the student's stage and the subject, when the subject is
+// locked, when it repeats an earlier row, when it gives a final mark to a student
+// barred for absence or when ValidateMark rejects it. Failed rows don't stop the others.
+func (app *application) writeMarks(r *http.Request, year string, rows []markRow) ([]*markResult, error) {
+	user, err := app.getUserFromContext(r)
+	if err != nil {
+		return nil, err
+	}
+	allStudents, _, err := app.models.Students.GetAll(year, "all", data.Filters{})
+	if err != nil {
+		return nil, err
+	}
+	students := make(map[int64]*data.Student, len(allStudents))
+	for _, student := range allStudents {
+		students[int64(student.StudentId)] = student
+	}
+	allSubjects, _, err := app.models.Subjects.GetAll(year, "all", data.Filters{})
+	if err != nil {
+		return nil, err
+	}
+	subjects := make(map[int64]*data.Subject, len(allSubjects))
+	for _, subject := range allSubjects {
+		subjects[int64(subject.ID)] = subject
+	}
+	banned, err := app.attendanceBans(year)
+	if err != nil {
+		return nil, err
+	}
+	access := make(map[string]bool)
+	locked := make(map[int64]bool)
+	seen := make(map[[2]int64]int)
+
+	results := make([]*markResult, len(rows))
+	var marks []*data.Mark
+	var written []*markResult
+	for i, row := range rows {
+		result := &markResult{Row: i + 1, StudentId: row.StudentId, SubjectId: row.SubjectId, Status: markFailed}
+		results[i] = result
+		v := validator.New()
+		student, ok := students[row.StudentId]
+		v.Check(ok, "رقم الطالب", "الطالب غير موجود")
+		subject, found := subjects[row.SubjectId]
+		v.Check(found, "رقم المادة", "المادة غير موجودة")
+		v.Check(row.SemesterMark != nil, "السعي", "يجب تزويد المعلومات")
+		v.Check(row.FinalMark != nil, "درجة الامتحان النهائي", "يجب تزويد المعلومات")
+		key := [2]int64{row.StudentId, row.SubjectId}
+		if first, ok := seen[key]; ok {
+			v.AddError("الصف", fmt.Sprintf("مكرر مع الصف %d", first))
+		} else {
+			seen[key] = i + 1
+		}
+		if !v.Valid() {
+			result.Errors = v.Errors
+			continue
+		}
+		stage := student.Stage
+		accessKey := fmt.Sprintf("%s/%d", stage, row.SubjectId)
+		hasAccess, ok := access[accessKey]
+		if !ok {
+			hasAccess, err = app.models.Privileges.CheckSubjectWriteAccess(int(user.ID), "marks_"+year, stage, row.SubjectId)
+			if err != nil {
+				return nil, err
+			}
+			access[accessKey] = hasAccess
+		}
+		if !hasAccess {
+			result.Errors = map[string]string{"الصلاحيات": "المستخدم غير مصرح له بادخال درجات هذه المادة لهذه المرحلة"}
+			continue
+		}
+		isLocked, ok := locked[row.SubjectId]
+		if !ok {
+			isLocked, err = app.models.Locks.IsSubjectLocked(year, row.SubjectId)
+			if err != nil {
+				return nil, err
+			}
+			locked[row.SubjectId] = isLocked
+		}
+		if isLocked {
+			result.Errors = map[string]string{"القفل": "النتائج مصادق عليها من اللجنة الامتحانية ولا يمكن تعديلها"}
+			continue
+		}
+		mark := &data.Mark{
+			StudentId:    row.StudentId,
+			SubjectId:    row.SubjectId,
+			SemesterMark: *row.SemesterMark,
+			FinalMark:    *row.FinalMark,
+		}
+		if mark.FinalMark > 0 {
+			v.Check(!banned[key], "درجة الامتحان النهائي", attendanceBanMessage)
+		}
+		if data.ValidateMark(v, mark, subject.MaxSemesterMark, subject.MaxFinalExam); !v.Valid() {
+			result.Errors = v.Errors
+			continue
+		}
+		mark.StudentName = student.StudentName
+		mark.SubjectName = subject.SubjectName
+		mark.MaxSemesterMark = subject.MaxSemesterMark
+		mark.MaxFinalExam = subject.MaxFinalExam
+		marks = append(marks, mark)
+		written = append(written, result)
+	}
+	if len(marks) == 0 {
+		return results, nil
+	}
+	changes, err := app.models.Marks.UpsertMany(year, marks, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	policy := app.gradePolicy()
+	for i, change := range changes {
+		result := written[i]
+		result.Mark = change.After
+		policy.Grade(result.Mark)
+		if change.Before == nil {
+			result.Status = markCreated
+		} else {
+			result.Status = markUpdated
+		}
+	}
+	return results, nil
+}
+
+// markSummary counts the results of a bulk write by status.
+func markSummary(results []*markResult) map[string]int {
+	summary := map[string]int{markCreated: 0, markUpdated: 0, markFailed: 0}
+	for _, result := range results {
+		summary[result.Status]++
+	}
+	return summary
+}
+
+// createMarks writes a whole sheet of marks in one request, see writeMarks.
+func (app *application) createMarks(w http.ResponseWriter, r *http.Request) {
+	year, err := app.readYearParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Marks []markRow `json:"marks"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(len(input.Marks) > 0, "marks", "يجب تزويد المعلومات")
+	v.Check(len(input.Marks) <= maxMarkRows, "marks", fmt.Sprintf("الحد الاقصى %d درجة في الطلب الواحد", maxMarkRows))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	results, err := app.writeMarks(r, year, input.Marks)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results, "summary": markSummary(results)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importMarks reads marks from the first sheet of an uploaded Excel file. The sheet has
+// a header row followed by rows of student_id, subject_id, semester_mark, final_mark.
+// When the form carries a subject_id the sheet belongs to that subject and its rows are
+// student_id, semester_mark, final_mark instead; any further columns (such as the
+// student's name) are ignored. Rows are written as in createMarks and problems are
+// reported per sheet row.
+func (app *application) importMarks(w http.ResponseWriter, r *http.Request) {
+	year, err := app.readYearParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = r.ParseMultipartForm(10 << 20) // 10 MB max memory
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "الحد الاقصى لحجم الملف هو mb 10 ")
+		return
+	}
+	var subjectId int64
+	if value := strings.TrimSpace(r.FormValue("subject_id")); value != "" {
+		subjectId, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || subjectId < 1 {
+			app.failedValidationResponse(w, r, map[string]string{"رقم المادة": "يجب ان يكون رقم صحيح"})
+			return
+		}
+	}
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "لم يتم ارفاق ملف")
+		return
+	}
+	defer file.Close()
+	filePath := "./uploads/" + filepath.Base(handler.Filename)
+	err = app.saveFile(file, filePath)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	rows, err := app.readExcel(filePath)
+	app.removeFile(filePath)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusBadRequest, "تعذر قراءة الملف")
+		return
+	}
+	if len(rows) > 0 {
+		rows = rows[1:] // remove header
+	}
+	if len(rows) > maxMarkRows {
+		app.failedValidationResponse(w, r, map[string]string{"file": fmt.Sprintf("الحد الاقصى %d درجة في الملف الواحد", maxMarkRows)})
+		return
+	}
+	// column positions of student_id, subject_id, semester_mark and final_mark
+	columns := []int{0, 1, 2, 3}
+	if subjectId != 0 {
+		columns = []int{0, -1, 1, 2}
+	}
+	allErrors := make(map[string]string)
+	var markRows []markRow
+	var sheetRows []int
+	for i, row := range rows {
+		if isBlankRow(row) {
+			continue
+		}
+		values := make([]int64, len(columns))
+		var errorMsgs []string
+		for c, column := range columns {
+			if column == -1 {
+				values[c] = subjectId
+				continue
+			}
+			var cell string
+			if column < len(row) {
+				cell = strings.TrimSpace(row[column])
+			}
+			value, err := strconv.ParseInt(cell, 10, 64)
+			if err != nil {
+				errorMsgs = append(errorMsgs, markColumns[c]+": يجب ان يكون رقم صحيح")
+				continue
+			}
+			values[c] = value
+		}
+		if len(errorMsgs) > 0 {
+			allErrors[fmt.Sprintf("row-%d", i+1)] = strings.Join(errorMsgs, ", ")
+			continue
+		}
+		semesterMark, finalMark := int(values[2]), int(values[3])
+		markRows = append(markRows, markRow{
+			StudentId:    values[0],
+			SubjectId:    values[1],
+			SemesterMark: &semesterMark,
+			FinalMark:    &finalMark,
+		})
+		sheetRows = append(sheetRows, i+1)
+	}
+	results, err := app.writeMarks(r, year, markRows)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for i, result := range results {
+		if result.Status != markFailed {
+			continue
+		}
+		var errorMsgs []string
+		for key, msg := range result.Errors {
+			errorMsgs = append(errorMsgs, key+": "+msg)
+		}
+		sort.Strings(errorMsgs)
+		allErrors[fmt.Sprintf("row-%d", sheetRows[i])] = strings.Join(errorMsgs, ", ")
+	}
+	summary := markSummary(results)
+	summary[markFailed] = len(allErrors)
+	env := envelope{"summary": summary}
+	if len(allErrors) > 0 {
+		env["errors"] = allErrors
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// markColumns names the columns of a marks sheet in error messages.
+var markColumns = []string{"رقم الطالب", "رقم المادة", "السعي", "درجة الامتحان النهائي"}
+
+func isBlankRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -16,12 +17,21 @@ const yearContextKey = contextKey("year")
 const stageContextKey = contextKey("stage")
 const idContextKey = contextKey("id")
 const customPrivsContextKey = contextKey("custom_privs")
+const subjectAccessContextKey = contextKey("subject_access")
 const studentContextKey = contextKey("student")
 const subjectContextKey = contextKey("subject")
 const markContextKey = contextKey("mark")
+const examContextKey = contextKey("exam")
 
 //const stagesContextKey = contextKey("stages")
 
+// derivedTables maps endpoints that are computed from another table to that table,
+// so they're guarded by the same privileges, e.g. averages are read from marks.
+var derivedTables = map[string]string{
+	"averages": "marks",
+	"reports":  "marks",
+}
+
 func (app *application) secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		allowedOrigins := map[string]bool{
@@ -65,6 +75,9 @@ func (app *application) getAllAccess(next http.Handler) http.Handler {
 		path := r.URL.Path
 		parts := strings.Split(path, "/")
 		cat := parts[2]
+		if table, ok := derivedTables[cat]; ok {
+			cat = table
+		}
 		year, err := app.readYearParam(r)
 		if err != nil {
 			app.notFoundResponse(w, r)
@@ -81,6 +94,25 @@ func (app *application) getAllAccess(next http.Handler) http.Handler {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
+		ctx := context.WithValue(r.Context(), yearContextKey, year)
+		ctx = context.WithValue(ctx, stageContextKey, stage)
+		// marks and attendance can be granted per subject, the handlers only return the
+		// granted ones.
+		if parts[2] == "marks" || parts[2] == "attendance" {
+			access, err := app.models.Privileges.GetSubjectAccess(int(user.ID), tableName, stage, false)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if access.None() {
+				app.unauthorized(w, r)
+				return
+			}
+			ctx = context.WithValue(ctx, subjectAccessContextKey, access)
+			r = r.WithContext(ctx)
+			next.ServeHTTP(w, r)
+			return
+		}
 		privilege, err := app.models.Privileges.CheckAccess(int(user.ID), tableName, stage)
 		if err != nil {
 			if err == data.ErrRecordNotFound {
@@ -94,8 +126,6 @@ func (app *application) getAllAccess(next http.Handler) http.Handler {
 			app.unauthorized(w, r)
 			return
 		}
-		ctx := context.WithValue(r.Context(), yearContextKey, year)
-		ctx = context.WithValue(ctx, stageContextKey, stage)
 		//ctx = context.WithValue(ctx, stagesContextKey, stages)
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
@@ -133,6 +163,27 @@ func (app *application) getAllAccess(next http.Handler) http.Handler {
 // 	})
 // }
 
+// yearWritable refuses requests that would change an archived year.
+func (app *application) yearWritable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		year, err := app.readYearParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		archived, err := app.models.Years.IsArchived(year)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if archived {
+			app.archivedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) writeAccess(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, err := app.getUserFromContext(r)
@@ -215,12 +266,30 @@ func (app *application) writeAccess(next http.Handler) http.Handler {
 				app.serverErrorResponse(w, r, err)
 				return
 			}
+		case "exams":
+			exam, err := app.models.Exams.Get(year, id)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.notFoundResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			stage = exam.Stage
+			ctx = context.WithValue(ctx, examContextKey, exam)
 		default:
 			app.notFoundResponse(w, r)
 			return
 		}
 
-		hasAccess, err := app.models.Privileges.CheckWriteAccess(int(user.ID), tableName, stage)
+		var hasAccess bool
+		if mark != nil {
+			hasAccess, err = app.models.Privileges.CheckSubjectWriteAccess(int(user.ID), tableName, stage, mark.SubjectId)
+		} else {
+			hasAccess, err = app.models.Privileges.CheckWriteAccess(int(user.ID), tableName, stage)
+		}
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -34,7 +34,12 @@ func (app *application) getPrivileges(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"privileges": privileges, "user": user}, nil)
+	userRoles, err := app.models.Roles.GetAssignments(int(userId))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"privileges": privileges, "user": user, "roles": userRoles}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -81,11 +86,34 @@ func (app *application) createPrivilege(w http.ResponseWriter, r *http.Request)
 		privilege.SubjectId = -1
 	}
 	v := validator.New()
+	// only marks and attendance can be granted per subject; the grant is stored under
+	// the subject's stage.
+	if privilege.SubjectId != -1 {
+		// the year names the table the subject is looked up in, so it's checked first.
+		v.Check(input.TableName == "marks" || input.TableName == "attendance", "المادة", "يمكن تحديد المادة لصلاحيات الدرجات والحضور فقط")
+		if data.ValidateYear(v, &data.Year{Year: privilege.Year}); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		subject, err := app.models.Subjects.Get(privilege.Year, int64(privilege.SubjectId))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("المادة", "المادة غير موجودة")
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		} else {
+			v.Check(privilege.Stage == "all" || privilege.Stage == subject.Stage, "المرحلة", "المادة لا تنتمي لهذه المرحلة")
+			privilege.Stage = subject.Stage
+		}
+	}
 	if data.ValidatePrivilege(v, privilege); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	if input.TableName == "users" || input.TableName == "privileges" || input.TableName == "years" {
+	if data.IsGlobalTable(input.TableName) {
 		privilege.SubjectId = -1
 		privilege.Stage = "all"
 		privilege.Year = "all"
@@ -95,11 +123,12 @@ func (app *application) createPrivilege(w http.ResponseWriter, r *http.Request)
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	if input.TableName == "users" || input.TableName == "privileges" || input.TableName == "years" {
+	if data.IsGlobalTable(input.TableName) {
 		privilege.TableName = input.TableName
 	} else {
 		privilege.TableName = input.TableName + "_" + input.Year
 	}
+	app.audit(r, privilege.Year, "privileges", int64(privilege.UserId), data.AuditCreate, nil, privilege)
 	err = app.writeJSON(w, http.StatusCreated, envelope{"privilege": privilege}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -108,10 +137,11 @@ func (app *application) createPrivilege(w http.ResponseWriter, r *http.Request)
 
 func (app *application) deletePrivilege(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		UserId  int    `json:"user_id"`
-		Year    string `json:"year"`
-		TableId int    `json:"table_id"`
-		Stage   string `json:"stage"`
+		UserId    int    `json:"user_id"`
+		Year      string `json:"year"`
+		TableId   int    `json:"table_id"`
+		Stage     string `json:"stage"`
+		SubjectId *int   `json:"subject_id"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -124,10 +154,41 @@ func (app *application) deletePrivilege(w http.ResponseWriter, r *http.Request)
 		TableId: input.TableId,
 		Stage:   input.Stage,
 	}
+	// without a subject_id the privilege is removed for every subject of the stage.
+	if input.SubjectId != nil {
+		privilege.SubjectId = *input.SubjectId
+	}
+	// per-subject grants are stored under the subject's stage, see createPrivilege.
+	if privilege.SubjectId > 0 {
+		v := validator.New()
+		if data.ValidateYear(v, &data.Year{Year: privilege.Year}); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		subject, err := app.models.Subjects.Get(privilege.Year, int64(privilege.SubjectId))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if privilege.Stage == "all" {
+			privilege.Stage = subject.Stage
+		}
+	}
 	err = app.models.Privileges.Delete(privilege)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
+	app.audit(r, privilege.Year, "privileges", int64(privilege.UserId), data.AuditDelete, privilege, nil)
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"collegecm.hamid.net/internal/data"
+	"github.com/xuri/excelize/v2"
+)
+
+// getRanking returns the class order of a stage. An optional ?department= limits the
+// averages to that department's subjects.
+func (app *application) getRanking(w http.ResponseWriter, r *http.Request) {
+	year, err := app.getYearFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	stage, err := app.getStageFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	department := strings.TrimSpace(r.URL.Query().Get("department"))
+	var averages []*data.StudentAverage
+	if department == "" {
+		averages, err = app.models.Averages.GetAll(year, stage, app.gradePolicy())
+	} else {
+		averages, err = app.models.Averages.GetByDepartment(year, stage, department, app.gradePolicy())
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	ranking := data.Rank(averages, app.config.reports.excludedStates)
+	err = app.writeJSON(w, http.StatusOK, envelope{"ranking": ranking, "department": department}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// decisionNames are the Arabic wordings of the promotion decisions on the master sheet.
+var decisionNames = map[string]string{
+	data.DecisionPromoted:  "ناجح",
+	data.DecisionCarryover: "ناجح بتحميل",
+	data.DecisionRepeat:    "راسب",
+	data.DecisionGraduated: "متخرج",
+}
+
+// markColumnNames are the columns under each subject of the master sheet.
+var markColumnNames = []string{"السعي", "النهائي", "المجموع", "التقدير"}
+
+// getMasterSheet sends the stage's official results sheet as an Excel workbook.
+func (app *application) getMasterSheet(w http.ResponseWriter, r *http.Request) {
+	year, err := app.getYearFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	stage, err := app.getStageFromContext(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if stage == "all" {
+		app.failedValidationResponse(w, r, map[string]string{"المرحلة": "يجب تحديد المرحلة"})
+		return
+	}
+	sheet, err := app.models.MasterSheets.Get(year, stage, app.gradePolicy(), app.promotionRules())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	f, err := masterSheetWorkbook(sheet)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer f.Close()
+	filename := fmt.Sprintf("master_sheet_%s_%s.xlsx", year, r.PathValue("stage"))
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	err = f.Write(w)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// masterSheetWorkbook lays the sheet out as: a title row, a header row with the
+// student columns, one merged group per subject and the average and decision, a
+// second header row with the mark columns of each subject, then a row per student.
+func masterSheetWorkbook(sheet *data.MasterSheet) (*excelize.File, error) {
+	f := excelize.NewFile()
+	name := "النتائج"
+	err := f.SetSheetName(f.GetSheetName(0), name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	err = buildMasterSheet(f, name, sheet)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func buildMasterSheet(f *excelize.File, name string, sheet *data.MasterSheet) error {
+	err := setRightToLeft(f, name)
+	if err != nil {
+		return err
+	}
+	border := []excelize.Border{
+		{Type: "left", Color: "000000", Style: 1},
+		{Type: "right", Color: "000000", Style: 1},
+		{Type: "top", Color: "000000", Style: 1},
+		{Type: "bottom", Color: "000000", Style: 1},
+	}
+	center := &excelize.Alignment{Horizontal: "center", Vertical: "center", WrapText: true}
+	titleStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}, Alignment: center})
+	if err != nil {
+		return err
+	}
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Alignment: center,
+		Border:    border,
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"D9D9D9"}},
+	})
+	if err != nil {
+		return err
+	}
+	cellStyle, err := f.NewStyle(&excelize.Style{Alignment: center, Border: border})
+	if err != nil {
+		return err
+	}
+	failStyle, err := f.NewStyle(&excelize.Style{Alignment: center, Border: border, Font: &excelize.Font{Color: "C00000"}})
+	if err != nil {
+		return err
+	}
+
+	// fixed columns: ت، رقم الطالب، اسم الطالب; then the subjects, then المعدل and القرار.
+	const fixed = 3
+	lastCol := fixed + len(sheet.Subjects)*len(markColumnNames) + 2
+	cell := func(col, row int) string {
+		name, _ := excelize.CoordinatesToCellName(col, row)
+		return name
+	}
+	put := func(col1, row1, col2, row2 int, value interface{}, style int) error {
+		if col1 != col2 || row1 != row2 {
+			if err := f.MergeCell(name, cell(col1, row1), cell(col2, row2)); err != nil {
+				return err
+			}
+		}
+		if err := f.SetCellValue(name, cell(col1, row1), value); err != nil {
+			return err
+		}
+		return f.SetCellStyle(name, cell(col1, row1), cell(col2, row2), style)
+	}
+
+	title := fmt.Sprintf("نتائج طلبة المرحلة %s للعام الدراسي %s", sheet.Stage, strings.ReplaceAll(sheet.Year, "_", "-"))
+	if err = put(1, 1, lastCol, 1, title, titleStyle); err != nil {
+		return err
+	}
+	for i, header := range []string{"ت", "رقم الطالب", "اسم الطالب"} {
+		if err = put(i+1, 2, i+1, 3, header, headerStyle); err != nil {
+			return err
+		}
+	}
+	col := fixed + 1
+	for _, subject := range sheet.Subjects {
+		width := len(markColumnNames)
+		if err = put(col, 2, col+width-1, 2, subject.SubjectName, headerStyle); err != nil {
+			return err
+		}
+		for i, header := range markColumnNames {
+			if err = put(col+i, 3, col+i, 3, header, headerStyle); err != nil {
+				return err
+			}
+		}
+		col += width
+	}
+	if err = put(col, 2, col, 3, "المعدل", headerStyle); err != nil {
+		return err
+	}
+	if err = put(col+1, 2, col+1, 3, "القرار", headerStyle); err != nil {
+		return err
+	}
+
+	for i, student := range sheet.Rows {
+		rowNum := i + 4
+		values := []interface{}{i + 1, student.StudentId, student.StudentName}
+		styles := []int{cellStyle, cellStyle, cellStyle}
+		for _, subject := range sheet.Subjects {
+			sem, fin, total, grade := interface{}(nil), interface{}(nil), interface{}(nil), interface{}(nil)
+			style := cellStyle
+			switch c := student.Cells[int64(subject.ID)]; {
+			case c == nil:
+			case c.Exempted:
+				grade = "معفو"
+			case c.Mark != nil:
+				sem, fin, total, grade = c.Mark.SemesterMark, c.Mark.FinalMark, c.Mark.Total, c.Mark.Grade
+				if c.Mark.Round == 2 && c.Mark.SecondFinalMark != nil {
+					fin = *c.Mark.SecondFinalMark
+				}
+				if !c.Mark.Passed {
+					style = failStyle
+				}
+			case c.Carryover:
+				grade = "محمل"
+			}
+			values = append(values, sem, fin, total, grade)
+			styles = append(styles, style, style, style, style)
+		}
+		decision, ok := decisionNames[student.Decision]
+		if !ok {
+			decision = student.State
+		}
+		values = append(values, student.Average, decision)
+		styles = append(styles, cellStyle, cellStyle)
+		for j, value := range values {
+			if err = put(j+1, rowNum, j+1, rowNum, value, styles[j]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = f.SetColWidth(name, "A", "B", 10); err != nil {
+		return err
+	}
+	if err = f.SetColWidth(name, "C", "C", 30); err != nil {
+		return err
+	}
+	if err = f.SetRowHeight(name, 2, 45); err != nil {
+		return err
+	}
+	// keep the headers and the student names in view while scrolling.
+	return f.SetPanes(name, &excelize.Panes{
+		Freeze:      true,
+		XSplit:      fixed,
+		YSplit:      3,
+		TopLeftCell: cell(fixed+1, 4),
+		ActivePane:  "bottomRight",
+	})
+}
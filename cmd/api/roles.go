@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/validator"
+)
+
+func (app *application) getRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRole(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string            `json:"name"`
+		Description string            `json:"description"`
+		Grants      []*data.RoleGrant `json:"grants"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	role := &data.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Grants:      input.Grants,
+	}
+	if role.Grants == nil {
+		role.Grants = []*data.RoleGrant{}
+	}
+	v := validator.New()
+	if data.ValidateRole(v, role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Roles.Insert(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("الاسم", "يوجد دور بهذا الاسم")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.audit(r, "all", "roles", role.ID, data.AuditCreate, nil, role)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateRole changes the role's name or description; grants, when given, replace the
+// current ones.
+func (app *application) updateRole(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	role, err := app.models.Roles.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	before := *role
+	var input struct {
+		Name        *string           `json:"name"`
+		Description *string           `json:"description"`
+		Grants      []*data.RoleGrant `json:"grants"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		role.Name = *input.Name
+	}
+	if input.Description != nil {
+		role.Description = *input.Description
+	}
+	if input.Grants != nil {
+		role.Grants = input.Grants
+	}
+	v := validator.New()
+	if data.ValidateRole(v, role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Roles.Update(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("الاسم", "يوجد دور بهذا الاسم")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.audit(r, "all", "roles", role.ID, data.AuditUpdate, before, role)
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteRole(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	role, err := app.models.Roles.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Roles.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.audit(r, "all", "roles", id, data.AuditDelete, role, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم الحذف بنجاح"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getUserRoles lists the roles assigned to the user with the given id.
+func (app *application) getUserRoles(w http.ResponseWriter, r *http.Request) {
+	userId, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	userRoles, err := app.models.Roles.GetAssignments(int(userId))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"user_roles": userRoles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserRole reads and validates the body of an assign or unassign request. It
+// returns nil when a response has already been sent.
+func (app *application) readUserRole(w http.ResponseWriter, r *http.Request) *data.UserRole {
+	var input struct {
+		UserId    int    `json:"user_id"`
+		RoleId    int64  `json:"role_id"`
+		Year      string `json:"year"`
+		Stage     string `json:"stage"`
+		SubjectId *int   `json:"subject_id"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil
+	}
+	userRole := &data.UserRole{
+		UserId:    input.UserId,
+		RoleId:    input.RoleId,
+		Year:      input.Year,
+		Stage:     input.Stage,
+		SubjectId: -1,
+	}
+	if input.SubjectId != nil {
+		userRole.SubjectId = *input.SubjectId
+	}
+	v := validator.New()
+	if data.ValidateUserRole(v, userRole); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+	return userRole
+}
+
+func (app *application) assignRole(w http.ResponseWriter, r *http.Request) {
+	userRole := app.readUserRole(w, r)
+	if userRole == nil {
+		return
+	}
+	v := validator.New()
+	role, err := app.models.Roles.Get(userRole.RoleId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("الدور", "الدور غير موجود")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		userRole.RoleName = role.Name
+		// roles from before grants were limited to year tables may still hold global ones.
+		for _, grant := range role.Grants {
+			v.Check(validator.In(grant.TableName, data.YearTables...), "الدور", "الدور يمنح صلاحيات على جدول عام: "+grant.TableName)
+		}
+	}
+	_, err = app.models.Users.Get(int64(userRole.UserId))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("المستخدم", "المستخدم غير موجود")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if userRole.Year != "all" {
+		exists, err := app.models.Years.Exists(userRole.Year)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(exists, "السنة", "السنة غير موجودة")
+		if exists && userRole.SubjectId != -1 {
+			_, err = app.models.Subjects.Get(userRole.Year, int64(userRole.SubjectId))
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					v.AddError("المادة", "المادة غير موجودة")
+				default:
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Roles.Assign(userRole)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r, userRole.Year, "user_roles", int64(userRole.UserId), data.AuditCreate, nil, userRole)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user_role": userRole}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unassignRole(w http.ResponseWriter, r *http.Request) {
+	userRole := app.readUserRole(w, r)
+	if userRole == nil {
+		return
+	}
+	err := app.models.Roles.Unassign(userRole)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.audit(r, userRole.Year, "user_roles", int64(userRole.UserId), data.AuditDelete, userRole, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم الحذف بنجاح"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
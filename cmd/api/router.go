@@ -15,7 +15,9 @@ func (app *application) routes() http.Handler {
 	auth := alice.New(app.isLoggedIn)
 	getAll := alice.New(app.isLoggedIn, app.getAllAccess)
 	// create := alice.New(app.isLoggedIn, app.createAccess)
-	write := alice.New(app.isLoggedIn, app.writeAccess)
+	write := alice.New(app.isLoggedIn, app.yearWritable, app.writeAccess)
+	// insert is for the endpoints adding rows to a year, which check privileges themselves.
+	insert := alice.New(app.isLoggedIn, app.yearWritable)
 	custom := alice.New(app.isLoggedIn, app.customAccess)
 	userRead := alice.New(app.isLoggedIn, app.userReadAccess)
 	userWrite := alice.New(app.isLoggedIn, app.userWriteAccess)
@@ -28,15 +30,16 @@ func (app *application) routes() http.Handler {
 	// subjects
 	router.Handle("GET /v1/subjects/{year}/{stage}", getAll.ThenFunc(app.getSubjects))
 	//router.Handle("GET /v1/subject/{year}/{id}", auth.ThenFunc(app.getSubjectHandler))
-	router.Handle("POST /v1/subjects/{year}", auth.ThenFunc(app.createSubjectHandler))
-	router.Handle("POST /v1/subjects/import/{year}", auth.ThenFunc(app.importSubjects))
+	router.Handle("POST /v1/subjects/{year}", insert.ThenFunc(app.createSubjectHandler))
+	router.Handle("POST /v1/subjects/import/{year}", insert.ThenFunc(app.importSubjects))
 	router.Handle("PATCH /v1/subjects/{year}/{id}", write.ThenFunc(app.updateSubject))
 	router.Handle("DELETE /v1/subjects/{year}/{id}", write.ThenFunc(app.deleteSubject))
 	// students
 	router.Handle("GET /v1/students/{year}/{stage}", getAll.ThenFunc(app.getStudents))
+	router.Handle("GET /v1/students/history/{id}", auth.ThenFunc(app.getStudentHistory))
 	//router.Handle("GET /v1/student/{year}/{id}", auth.ThenFunc(app.getStudent))
-	router.Handle("POST /v1/students/{year}", auth.ThenFunc(app.createStudent))
-	router.Handle("POST /v1/students/import/{year}", auth.ThenFunc(app.importstudents))
+	router.Handle("POST /v1/students/{year}", insert.ThenFunc(app.createStudent))
+	router.Handle("POST /v1/students/import/{year}", insert.ThenFunc(app.importstudents))
 	router.Handle("PATCH /v1/students/{year}/{id}", write.ThenFunc(app.updateStudent))
 	router.Handle("DELETE /v1/students/{year}/{id}", write.ThenFunc(app.deleteStudent))
 	// carryovers
@@ -45,7 +48,7 @@ func (app *application) routes() http.Handler {
 	//router.Handle("GET /v1/carryovers/find/{year}/{student_id}/{subject_id}", auth.ThenFunc(app.findCarryover))
 	//router.Handle("GET /v1/carryovers/subjects/{year}/{id}", auth.ThenFunc(app.getSubjectsCarryovers))
 	//router.Handle("GET /v1/carryovers/students/{year}/{id}", auth.ThenFunc(app.getStudentsCarryovers))
-	router.Handle("POST /v1/carryovers/{year}", auth.ThenFunc(app.createCarryover))
+	router.Handle("POST /v1/carryovers/{year}", insert.ThenFunc(app.createCarryover))
 	router.Handle("DELETE /v1/carryovers/{year}/{id}", write.ThenFunc(app.deleteCarryover))
 	// exempteds
 	router.Handle("GET /v1/exempted/{year}/{stage}", getAll.ThenFunc(app.getExempteds))
@@ -53,14 +56,36 @@ func (app *application) routes() http.Handler {
 	//router.Handle("GET /v1/exempteds/find/{student_id}/{subject_id}", auth.ThenFunc(app.findExempted))
 	//router.Handle("GET /v1/exempteds/subjects/{year}/{id}", auth.ThenFunc(app.getSubjectsExempteds))
 	//router.Handle("GET /v1/exempteds/students/{year}/{id}", auth.ThenFunc(app.getStudentsExempteds))
-	router.Handle("POST /v1/exempteds/{year}", auth.ThenFunc(app.createExempted))
+	router.Handle("POST /v1/exempteds/{year}", insert.ThenFunc(app.createExempted))
 	router.Handle("DELETE /v1/exempteds/{year}/{id}", write.ThenFunc(app.deleteExempted))
 	// marks
 	router.Handle("GET /v1/marks/{year}/{stage}", getAll.ThenFunc(app.getMarks))
+	router.Handle("GET /v1/marks/second-round/{year}/{stage}", getAll.ThenFunc(app.getSecondRoundMarks))
 	//router.Handle("GET /v1/mark/{year}/{id}", auth.ThenFunc(app.getMark))
-	router.Handle("POST /v1/marks/{year}", auth.ThenFunc(app.createMark))
+	router.Handle("POST /v1/marks/{year}", insert.ThenFunc(app.createMark))
+	router.Handle("POST /v1/marks/bulk/{year}", insert.ThenFunc(app.createMarks))
+	router.Handle("POST /v1/marks/import/{year}", insert.ThenFunc(app.importMarks))
 	router.Handle("PATCH /v1/marks/{year}/{id}", write.ThenFunc(app.updateMark))
 	router.Handle("DELETE /v1/marks/{year}/{id}", write.ThenFunc(app.deleteMark))
+	// attendance
+	router.Handle("GET /v1/attendance/{year}/{stage}", getAll.ThenFunc(app.getAttendance))
+	router.Handle("POST /v1/attendance/{year}", insert.ThenFunc(app.recordAttendance))
+	router.Handle("DELETE /v1/attendance/{year}", insert.ThenFunc(app.deleteAttendance))
+	// exams
+	router.Handle("GET /v1/exams/{year}/{stage}", getAll.ThenFunc(app.getExams))
+	router.Handle("GET /v1/exams/conflicts/{year}/{stage}", getAll.ThenFunc(app.getExamConflicts))
+	router.Handle("POST /v1/exams/{year}", insert.ThenFunc(app.createExam))
+	router.Handle("PATCH /v1/exams/{year}/{id}", write.ThenFunc(app.updateExam))
+	router.Handle("DELETE /v1/exams/{year}/{id}", write.ThenFunc(app.deleteExam))
+	// averages
+	router.Handle("GET /v1/averages/{year}/{stage}", getAll.ThenFunc(app.getAverages))
+	// reports
+	router.Handle("GET /v1/reports/ranking/{year}/{stage}", getAll.ThenFunc(app.getRanking))
+	router.Handle("GET /v1/reports/master-sheet/{year}/{stage}", getAll.ThenFunc(app.getMasterSheet))
+	// search
+	router.Handle("GET /v1/search/{year}", auth.ThenFunc(app.search))
+	// transcripts
+	router.Handle("GET /v1/transcripts/{id}", auth.ThenFunc(app.getTranscript))
 	// users
 	router.Handle("GET /v1/users", userRead.ThenFunc(app.getUsers))
 	router.Handle("GET /v1/users/{id}", userRead.ThenFunc(app.getUser))
@@ -71,6 +96,20 @@ func (app *application) routes() http.Handler {
 	router.Handle("GET /v1/privileges/{id}", userRead.ThenFunc(app.getPrivileges))
 	router.Handle("POST /v1/privileges", userWrite.ThenFunc(app.createPrivilege))
 	router.Handle("DELETE /v1/privileges", userWrite.ThenFunc(app.deletePrivilege))
+	// roles
+	router.Handle("GET /v1/roles", userRead.ThenFunc(app.getRoles))
+	router.Handle("POST /v1/roles", userWrite.ThenFunc(app.createRole))
+	router.Handle("PATCH /v1/roles/{id}", userWrite.ThenFunc(app.updateRole))
+	router.Handle("DELETE /v1/roles/{id}", userWrite.ThenFunc(app.deleteRole))
+	router.Handle("GET /v1/roles/users/{id}", userRead.ThenFunc(app.getUserRoles))
+	router.Handle("POST /v1/roles/assign", userWrite.ThenFunc(app.assignRole))
+	router.Handle("DELETE /v1/roles/assign", userWrite.ThenFunc(app.unassignRole))
+	// audit
+	router.Handle("GET /v1/audit", userRead.ThenFunc(app.getAuditLog))
+	// locks
+	router.Handle("GET /v1/locks/{year}", userRead.ThenFunc(app.getLocks))
+	router.Handle("POST /v1/locks", userWrite.ThenFunc(app.createLock))
+	router.Handle("DELETE /v1/locks", userWrite.ThenFunc(app.deleteLock))
 	// auth
 	router.HandleFunc("GET /v1/auth/status", app.authStatus)
 	router.HandleFunc("POST /v1/login", app.login)
@@ -81,6 +120,13 @@ func (app *application) routes() http.Handler {
 	router.Handle("GET /v1/years", auth.ThenFunc(app.getYears))
 	router.Handle("POST /v1/years", userWrite.ThenFunc(app.createYear))
 	router.Handle("DELETE /v1/years", userWrite.ThenFunc(app.deleteYear))
+	router.Handle("POST /v1/years/promote", userWrite.ThenFunc(app.promoteYear))
+	router.Handle("GET /v1/years/consistency", userRead.ThenFunc(app.checkYears))
+	router.Handle("POST /v1/years/consistency", userWrite.ThenFunc(app.repairYears))
+	router.Handle("POST /v1/years/archive", userWrite.ThenFunc(app.archiveYear))
+	router.Handle("POST /v1/years/unarchive", userWrite.ThenFunc(app.unarchiveYear))
+	router.Handle("GET /v1/years/archives", userRead.ThenFunc(app.getArchives))
+	router.Handle("POST /v1/years/restore", userWrite.ThenFunc(app.restoreYear))
 	// Return the httprouter instance.
 	return standard.Then(router)
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"unicode/utf8"
+
+	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/validator"
+)
+
+// search finds the year's students and subjects whose name or id matches ?q=, best
+// matches first. Only the stages the caller can read are searched; ?limit= caps each
+// list (default 20, at most 100).
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+	year, err := app.readYearParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	exists, err := app.models.Years.Exists(year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !exists {
+		app.notFoundResponse(w, r)
+		return
+	}
+	qs := r.URL.Query()
+	v := validator.New()
+	q := data.NormalizeArabic(app.readString(qs, "q", ""))
+	limit := app.readInt(qs, "limit", 20, v)
+	v.Check(q != "", "q", "يجب تزويد المعلومات")
+	v.Check(utf8.RuneCountInString(q) <= 100, "q", "يجب ان لا يزيد عن 100 حرف")
+	v.Check(limit > 0 && limit <= 100, "limit", "يجب ان يكون بين 1 و 100")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.getUserFromContext(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	studentStages, err := app.models.Privileges.ReadableStages(int(user.ID), "students_"+year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	subjectStages, err := app.models.Privileges.ReadableStages(int(user.ID), "subjects_"+year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(studentStages) == 0 && len(subjectStages) == 0 {
+		app.unauthorized(w, r)
+		return
+	}
+	students, err := app.models.Search.Students(year, q, studentStages, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	subjects, err := app.models.Search.Subjects(year, q, subjectStages, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"query": q, "students": students, "subjects": subjects}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -22,7 +22,13 @@ func (app *application) getStudents(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
-	students, err := app.models.Students.GetAll(year, stage)
+	v := validator.New()
+	filters := app.readFilters(r, v, "seq_in_college", "student_id", "student_name", "state")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	students, metadata, err := app.models.Students.GetAll(year, stage, filters)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -32,7 +38,11 @@ func (app *application) getStudents(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"students": students}, nil)
+	if app.exportRequested(r) {
+		app.export(w, r, "students", studentHeaders, studentRows(students))
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"students": students, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -109,6 +119,7 @@ func (app *application) createStudent(w http.ResponseWriter, r *http.Request) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, year, "students", int64(student.StudentId), data.AuditCreate, nil, student)
 	err = app.writeJSON(w, http.StatusCreated, envelope{"student": student}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -136,6 +147,7 @@ func (app *application) updateStudent(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	before := *student
 	var input struct {
 		StudentName *string `json:"student_name"`
 		Stage       *string `json:"stage"`
@@ -169,6 +181,7 @@ func (app *application) updateStudent(w http.ResponseWriter, r *http.Request) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, year, "students", int64(before.StudentId), data.AuditUpdate, before, student)
 	err = app.writeJSON(w, http.StatusOK, envelope{"student": student}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -186,6 +199,9 @@ func (app *application) deleteStudent(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
+	if app.studentLocked(w, r, year, id) {
+		return
+	}
 	err = app.models.Students.Delete(year, id)
 	if err != nil {
 		switch {
@@ -196,6 +212,10 @@ func (app *application) deleteStudent(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	student, err := app.getStudentFromContext(r)
+	if err == nil {
+		app.audit(r, year, "students", id, data.AuditDelete, student, nil)
+	}
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم حذف الطالب بنجاح"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -259,11 +279,13 @@ func (app *application) importstudents(w http.ResponseWriter, r *http.Request) {
 		err = app.models.Students.Insert(year, student)
 		if err != nil {
 			allErrors[fmt.Sprintf("row-%d", i+1)] = "رقم الطالب مكرر او حدث خطأ"
+			continue
 		}
+		app.audit(r, year, "students", int64(student.StudentId), data.AuditCreate, nil, student)
 	}
 	app.removeFile(filePath)
 	// get all subjects or redirect
-	allStudents, err := app.models.Students.GetAll(year, "all")
+	allStudents, _, err := app.models.Students.GetAll(year, "all", data.Filters{})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -285,3 +307,55 @@ func (app *application) importstudents(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// getStudentHistory returns the student's stage, state, carryovers and results in every
+// year they were enrolled in. Years the caller can't read the students of are left
+// out, as are the carryovers and results they can't read.
+func (app *application) getStudentHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user, err := app.getUserFromContext(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	enrolments, err := app.models.Histories.Enrolments(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(enrolments) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	history := &data.StudentHistory{StudentId: id, Years: []*data.HistoryYear{}}
+	for _, student := range enrolments {
+		access, err := app.models.Privileges.CheckCustomAccess(int(user.ID), student.Year, student.Stage)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !access.Students {
+			continue
+		}
+		year, err := app.models.Histories.GetYear(student, app.gradePolicy(), access)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		history.StudentName = student.StudentName
+		history.Years = append(history.Years, year)
+	}
+	// a student the user can't see in any year looks the same as an unknown one.
+	if len(history.Years) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"history": history}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
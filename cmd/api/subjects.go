@@ -21,7 +21,13 @@ func (app *application) getSubjects(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
-	subjects, err := app.models.Subjects.GetAll(year, stage)
+	v := validator.New()
+	filters := app.readFilters(r, v, "subject_id", "subject_name", "semester", "department", "credits")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	subjects, metadata, err := app.models.Subjects.GetAll(year, stage, filters)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -31,7 +37,11 @@ func (app *application) getSubjects(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"subjects": subjects}, nil)
+	if app.exportRequested(r) {
+		app.export(w, r, "subjects", subjectHeaders, subjectRows(subjects))
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"subjects": subjects, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -144,6 +154,7 @@ func (app *application) createSubjectHandler(w http.ResponseWriter, r *http.Requ
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, year, "subjects", int64(subject.ID), data.AuditCreate, nil, subject)
 	// When sending a HTTP response, we want to include a Location header to let the
 	// client know which URL they can find the newly-created resource at. We make an
 	// empty http.Header map and then use the Set() method to add a new Location header,
@@ -178,6 +189,7 @@ func (app *application) updateSubject(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	before := *subject
 	var input struct {
 		ID                 *int    `json:"subject_id"`
 		SubjectName        *string `json:"subject_name"`
@@ -249,6 +261,7 @@ func (app *application) updateSubject(w http.ResponseWriter, r *http.Request) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, year, "subjects", int64(before.ID), data.AuditUpdate, before, subject)
 	// Write the updated movie record in a JSON response.
 	err = app.writeJSON(w, http.StatusOK, envelope{"subject": subject}, nil)
 	if err != nil {
@@ -267,6 +280,10 @@ func (app *application) deleteSubject(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
+	// the subject's marks, carryovers and exemptions go with it.
+	if app.subjectLocked(w, r, year, id) {
+		return
+	}
 	// Delete the movie from the database, sending a 404 Not Found response to the
 	// client if there isn't a matching record.
 	err = app.models.Subjects.Delete(year, id)
@@ -279,6 +296,10 @@ func (app *application) deleteSubject(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	subject, err := app.getSubjectFromContext(r)
+	if err == nil {
+		app.audit(r, year, "subjects", id, data.AuditDelete, subject, nil)
+	}
 	// Return a 200 OK status code along with a success message.
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "subject successfully deleted"}, nil)
 	if err != nil {
@@ -287,7 +308,12 @@ func (app *application) deleteSubject(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) importSubjects(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseMultipartForm(10 << 20) // 10 MB max memory
+	year, err := app.readYearParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = r.ParseMultipartForm(10 << 20) // 10 MB max memory
 	if err != nil {
 		app.errorResponse(w, r, http.StatusBadRequest, "الحد الاقصى لحجم الملف هو mb 10 ")
 		return
@@ -318,14 +344,15 @@ func (app *application) importSubjects(w http.ResponseWriter, r *http.Request) {
 			allErrors[fmt.Sprintf("row-%d", i+1)] = strings.Join(errorMsgs, ", ")
 			continue
 		}
-		err = app.models.Subjects.Insert("", subject)
+		err = app.models.Subjects.Insert(year, subject)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
+		app.audit(r, year, "subjects", int64(subject.ID), data.AuditCreate, nil, subject)
 	}
 	// get all subjects or redirect
-	allSubjects, err := app.models.Subjects.GetAll("", "")
+	allSubjects, _, err := app.models.Subjects.GetAll(year, "all", data.Filters{})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"collegecm.hamid.net/internal/data"
+	"collegecm.hamid.net/internal/pdf"
+)
+
+// getTranscript returns the student's transcript across all years as JSON, or as a PDF
+// with ?format=pdf. The caller needs read access to the marks of every year and stage
+// the student was in; without it the answer is 404, as for an unknown id, so the
+// endpoint can't be used to find out which students exist.
+func (app *application) getTranscript(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	transcript, err := app.models.Transcripts.Get(id, app.gradePolicy())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, year := range transcript.Years {
+		ok, err := app.canRead(r, "marks", year.Year, year.Stage)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		err = app.writeJSON(w, http.StatusOK, envelope{"transcript": transcript}, nil)
+	case "pdf":
+		if problems := transcriptPDFProblems(transcript); len(problems) > 0 {
+			app.failedValidationResponse(w, r, problems)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"transcript_%d.pdf\"", id))
+		_, err = transcriptPDF(transcript).WriteTo(w)
+	default:
+		app.failedValidationResponse(w, r, map[string]string{"format": "يجب ان يكون json او pdf"})
+		return
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// gradeNames are the English grade names printed on the PDF transcript.
+var gradeNames = map[string]string{
+	data.GradeExcellent:  "Excellent",
+	data.GradeVeryGood:   "Very Good",
+	data.GradeGood:       "Good",
+	data.GradeAverage:    "Fair",
+	data.GradeAcceptable: "Pass",
+	data.GradeFail:       "Fail",
+}
+
+// transcriptPDFProblems lists what keeps the transcript from being printed. The PDF
+// fonts can't shape Arabic, so the student's name has to be in Latin letters and every
+// subject needs an English name; an official transcript can't leave either out.
+func transcriptPDFProblems(t *data.Transcript) map[string]string {
+	problems := make(map[string]string)
+	if !pdf.Encodable(t.StudentName) {
+		problems["اسم الطالب"] = "لا يمكن طباعة الاسم بالحروف العربية في ملف PDF، استخدم format=json"
+	}
+	var missing []string
+	for _, year := range t.Years {
+		for _, subject := range year.Subjects {
+			if strings.TrimSpace(subject.SubjectNameEnglish) == "" || !pdf.Encodable(subject.SubjectNameEnglish) {
+				missing = append(missing, fmt.Sprintf("%s/%d", year.Year, subject.SubjectId))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		problems["المواد"] = "المواد التالية بدون اسم انكليزي صالح: " + strings.Join(missing, ", ")
+	}
+	return problems
+}
+
+// transcriptPDF lays the transcript out in English, see transcriptPDFProblems.
+func transcriptPDF(t *data.Transcript) *pdf.Document {
+	doc := pdf.New(fmt.Sprintf("Transcript %d", t.StudentId))
+	const (
+		left   = 50.0
+		right  = pdf.PageWidth - 50
+		top    = pdf.PageHeight - 60
+		bottom = 60.0
+	)
+	columns := []float64{left, 330, 380, 430, 490}
+	page := doc.AddPage()
+	y := top
+	newPage := func() {
+		page = doc.AddPage()
+		y = top
+	}
+
+	page.Text(left, y, 18, true, "Academic Transcript")
+	y -= 30
+	page.Text(left, y, 11, false, fmt.Sprintf("Student ID: %d", t.StudentId))
+	y -= 16
+	page.Text(left, y, 11, false, "Name: "+t.StudentName)
+	y -= 16
+	page.Text(left, y, 11, false, fmt.Sprintf("Cumulative average: %.2f    Credits earned: %d", t.Average, t.Credits))
+	y -= 24
+
+	for _, year := range t.Years {
+		// keep a year's heading together with at least a couple of its subjects.
+		if y < bottom+80 {
+			newPage()
+		}
+		page.Line(left, y+12, right, y+12)
+		heading := fmt.Sprintf("Academic year %s - Stage %d", strings.ReplaceAll(year.Year, "_", "/"), data.StageNumber(year.Stage))
+		page.Text(left, y, 12, true, heading)
+		page.Text(columns[3], y, 10, false, fmt.Sprintf("Average: %.2f", year.Average))
+		y -= 18
+		for i, header := range []string{"Subject", "Credits", "Total", "%", "Grade"} {
+			page.Text(columns[i], y, 10, true, header)
+		}
+		y -= 14
+		for _, subject := range year.Subjects {
+			if y < bottom {
+				newPage()
+			}
+			name := subject.SubjectNameEnglish
+			if len(name) > 50 {
+				name = name[:47] + "..."
+			}
+			if subject.Carryover {
+				name += " (carried over)"
+			}
+			values := []string{name, fmt.Sprint(subject.Credits), "", "", "Exempted"}
+			if !subject.Exempted {
+				values[2] = fmt.Sprint(subject.Total)
+				values[3] = fmt.Sprintf("%.2f", subject.Percentage)
+				values[4] = gradeNames[subject.Grade]
+				if subject.Round == 2 {
+					values[4] += " (2nd round)"
+				}
+			}
+			for i, value := range values {
+				page.Text(columns[i], y, 10, false, value)
+			}
+			y -= 14
+		}
+		y -= 12
+	}
+	return doc
+}
@@ -60,18 +60,24 @@ func (app *application) createUser(w http.ResponseWriter, r *http.Request) {
 	}
 	user := &data.User{
 		Username: input.Username,
-		Password: input.Password,
 	}
 	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
 	if data.ValidateUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	err = user.SetPassword(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	err = app.models.Users.Insert(user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, "all", "users", user.ID, data.AuditCreate, nil, user)
 	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -94,6 +100,7 @@ func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	before := *user
 	var input struct {
 		Username *string `json:"username"`
 		Password *string `json:"password"`
@@ -106,19 +113,27 @@ func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
 	if input.Username != nil {
 		user.Username = *input.Username
 	}
+	v := validator.New()
 	if input.Password != nil {
-		user.Password = *input.Password
+		data.ValidatePasswordPlaintext(v, *input.Password)
 	}
-	v := validator.New()
 	if data.ValidateUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	if input.Password != nil {
+		err = user.SetPassword(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 	err = app.models.Users.Update(user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.audit(r, "all", "users", user.ID, data.AuditUpdate, before, user)
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -131,6 +146,16 @@ func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 	err = app.models.Users.Delete(id)
 	if err != nil {
 		switch {
@@ -141,6 +166,7 @@ func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	app.audit(r, "all", "users", id, data.AuditDelete, user, nil)
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "تم الحذف بنجاح"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
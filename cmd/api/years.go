@@ -25,9 +25,16 @@ func (app *application) getYears(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// createYear provisions a new academic year. With "copy_subjects_from" the subject
+// catalogue of an existing year is cloned into it, and with "copy_privileges" the
+// privileges on that year's tables are granted on the new year's tables as well; the
+// year and the copy are made together or not at all, and only into a new year. A
+// retried request without a copy answers 200 instead of 201.
 func (app *application) createYear(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Year string `json:"year"`
+		Year             string  `json:"year"`
+		CopySubjectsFrom *string `json:"copy_subjects_from"`
+		CopyPrivileges   bool    `json:"copy_privileges"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -38,24 +45,77 @@ func (app *application) createYear(w http.ResponseWriter, r *http.Request) {
 		Year: input.Year,
 	}
 	v := validator.New()
-	if data.ValidateYear(v, year); !v.Valid() {
+	data.ValidateYear(v, year)
+	if input.CopySubjectsFrom != nil {
+		from := &data.Year{Year: *input.CopySubjectsFrom}
+		fromV := validator.New()
+		data.ValidateYear(fromV, from)
+		v.Check(fromV.Valid() && from.Year != year.Year, "نسخ المواد من", "يحب ادخال سنة اكاديمية صحيحة")
+		if fromV.Valid() {
+			exists, err := app.models.Years.Exists(from.Year)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			v.Check(exists, "نسخ المواد من", "السنة الاكاديمية غير موجودة")
+		}
+	} else {
+		v.Check(!input.CopyPrivileges, "نسخ الصلاحيات", "يجب تحديد السنة المراد النسخ منها")
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Years.Insert(year)
+	var yearCopy *data.YearCopy
+	if input.CopySubjectsFrom != nil {
+		yearCopy = &data.YearCopy{From: *input.CopySubjectsFrom, Privileges: input.CopyPrivileges}
+	}
+	created, report, err := app.models.Years.Insert(year, yearCopy)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrYearExists):
+			app.copyTargetExists(w, r, year.Year)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"year": year}, nil)
+	env := envelope{"year": year}
+	if report != nil {
+		env["copy"] = report
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	err = app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// copyTargetExists refuses to copy into an existing year: 423 when it's archived,
+// otherwise 422.
+func (app *application) copyTargetExists(w http.ResponseWriter, r *http.Request, year string) {
+	archived, err := app.models.Years.IsArchived(year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if archived {
+		app.archivedResponse(w, r)
+		return
+	}
+	app.failedValidationResponse(w, r, map[string]string{"السنة الاكاديمية": "السنة الاكاديمية موجودة مسبقاً، لا يمكن النسخ اليها"})
+}
+
+// deleteYear drops a year and all its tables for good. It takes two requests: the
+// first is answered with a confirmation token, which the second must repeat as
+// "confirmation_token". Archiving keeps a year without the risk.
 func (app *application) deleteYear(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Year string `json:"year"`
+		Year              string `json:"year"`
+		ConfirmationToken string `json:"confirmation_token"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -66,10 +126,126 @@ func (app *application) deleteYear(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
+	exists, err := app.models.Years.Exists(input.Year)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !exists {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if !app.confirmYearDelete(w, r, input.Year, input.ConfirmationToken) {
+		return
+	}
 	err = app.models.Years.Delete(input.Year)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	app.audit(r, input.Year, "years", 0, data.AuditDelete, envelope{"year": input.Year}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"deleted": input.Year}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkYears reports where the years table, the tables catalogue and the tables in
+// the database disagree.
+func (app *application) checkYears(w http.ResponseWriter, r *http.Request) {
+	report, err := app.models.Consistency.Check()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"consistency": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// repairYears fixes what checkYears reports. The response holds what was found and
+// repaired, and a fresh check afterwards.
+func (app *application) repairYears(w http.ResponseWriter, r *http.Request) {
+	repaired, err := app.models.Consistency.Repair()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, scope := range repaired.Scopes {
+		app.audit(r, scope.Scope, "years", 0, data.AuditUpdate, scope, nil)
+	}
+	report, err := app.models.Consistency.Check()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"repaired": repaired, "consistency": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// promoteYear evaluates the results of a year and rolls its students into the next
+// year. With "dry_run" set nothing is written and only the report is returned.
+func (app *application) promoteYear(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Year   string `json:"year"`
+		DryRun bool   `json:"dry_run"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	year := &data.Year{
+		Year: input.Year,
+	}
+	v := validator.New()
+	if data.ValidateYear(v, year); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !input.DryRun {
+		// promotion writes the students into the next year.
+		next, err := data.NextAcademicYear(year.Year)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		archived, err := app.models.Years.IsArchived(next)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if archived {
+			app.archivedResponse(w, r)
+			return
+		}
+	}
+	report, err := app.models.Promotions.Promote(year.Year, app.gradePolicy(), app.promotionRules(), input.DryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNextYearMissing):
+			v.AddError("السنة الاكاديمية", "يجب انشاء السنة الاكاديمية التالية اولاً")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, student := range report.Students {
+		app.audit(r, report.ToYear, "students", int64(student.StudentId), data.AuditCreate, nil, student)
+	}
+	for _, carryover := range report.Carryovers {
+		app.audit(r, report.ToYear, "carryovers", carryover.Id, data.AuditCreate, nil, carryover)
+	}
+	status := http.StatusOK
+	if !input.DryRun && !report.Committed {
+		status = http.StatusUnprocessableEntity
+	}
+	err = app.writeJSON(w, status, envelope{"promotion": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
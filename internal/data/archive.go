@@ -0,0 +1,336 @@
+package data
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrYearExists     = errors.New("academic year already exists")
+	ErrInvalidArchive = errors.New("invalid archive")
+)
+
+// csvNull stands for NULL in the archived CSV files, as in PostgreSQL's COPY.
+const csvNull = `\N`
+
+// manifestFile is the name of the manifest inside an archive.
+const manifestFile = "manifest.json"
+
+// ArchiveManifest describes a year archive: a zip holding manifest.json and one CSV
+// file, with a header row, per year table.
+type ArchiveManifest struct {
+	Year      string          `json:"year"`
+	CreatedAt time.Time       `json:"created_at"`
+	Migration int64           `json:"migration"`
+	Tables    []*ArchiveTable `json:"tables"`
+}
+
+type ArchiveTable struct {
+	Name    string   `json:"name"`
+	File    string   `json:"file"`
+	Columns []string `json:"columns"`
+	Rows    int      `json:"rows"`
+}
+
+type ArchiveModel struct {
+	DB *sql.DB
+}
+
+// Dump writes the year's tables to w as a zip archive. The tables are read in one
+// snapshot, so the archive is consistent even if the year is written to meanwhile.
+func (m ArchiveModel) Dump(w io.Writer, year string) (*ArchiveManifest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM years WHERE year = $1)`, year).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+	manifest := &ArchiveManifest{Year: year, CreatedAt: time.Now().UTC()}
+	manifest.Migration, err = latestMigration(ctx, tx, year)
+	if err != nil {
+		return nil, err
+	}
+
+	zw := zip.NewWriter(w)
+	manifest.Tables, err = dumpTables(ctx, tx, zw, "", YearTables, "_"+year)
+	if err != nil {
+		return nil, err
+	}
+	err = writeManifest(zw, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, zw.Close()
+}
+
+// dumpTables writes each of tables, named with suffix in the database, to
+// dir/<table>.csv in the archive.
+func dumpTables(ctx context.Context, tx *sql.Tx, zw *zip.Writer, dir string, tables []string, suffix string) ([]*ArchiveTable, error) {
+	var dumped []*ArchiveTable
+	for _, table := range tables {
+		archived := &ArchiveTable{Name: table, File: path.Join(dir, table+".csv")}
+		fw, err := zw.Create(archived.File)
+		if err != nil {
+			return nil, err
+		}
+		err = dumpTable(ctx, tx, table+suffix, fw, archived)
+		if err != nil {
+			return nil, fmt.Errorf("dump %s: %w", table+suffix, err)
+		}
+		dumped = append(dumped, archived)
+	}
+	return dumped, nil
+}
+
+func writeManifest(zw *zip.Writer, manifest interface{}) error {
+	fw, err := zw.Create(manifestFile)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "\t")
+	return enc.Encode(manifest)
+}
+
+func readManifest(archive *zip.Reader, manifest interface{}) error {
+	f, err := archive.Open(manifestFile)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(manifest)
+	if err != nil {
+		return fmt.Errorf("%w: manifest: %v", ErrInvalidArchive, err)
+	}
+	return nil
+}
+
+// dumpTable writes the table as CSV. The columns are read in PostgreSQL's text format,
+// which COPY reads back as is; scanned through lib/pq, dates and times would come out
+// as Go timestamps, TIME columns in year 0, which don't restore.
+func dumpTable(ctx context.Context, tx *sql.Tx, name string, w io.Writer, archived *ArchiveTable) error {
+	columns, err := tableColumns(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	archived.Columns = columns
+	selected := make([]string, len(columns))
+	for i, column := range columns {
+		selected[i] = pq.QuoteIdentifier(column) + "::text"
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %s ORDER BY 1`, strings.Join(selected, ", "), name))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cw := csv.NewWriter(w)
+	err = cw.Write(archived.Columns)
+	if err != nil {
+		return err
+	}
+	values := make([]sql.NullString, len(archived.Columns))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(values))
+	for rows.Next() {
+		err := rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+		for i, value := range values {
+			record[i] = csvNull
+			if value.Valid {
+				record[i] = value.String
+			}
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+		archived.Rows++
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// tableColumns returns the column names of the table in their order.
+func tableColumns(ctx context.Context, tx *sql.Tx, name string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s LIMIT 0`, name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// ReadManifest returns the manifest of an archive made by Dump.
+func ReadManifest(archive *zip.Reader) (*ArchiveManifest, error) {
+	var manifest ArchiveManifest
+	err := readManifest(archive, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	err = manifest.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func (manifest *ArchiveManifest) validate() error {
+	if !isValidAcademicYear(manifest.Year) {
+		return fmt.Errorf("%w: invalid year %q", ErrInvalidArchive, manifest.Year)
+	}
+	for _, table := range manifest.Tables {
+		if _, _, ok := splitYearTable(table.Name + "_" + manifest.Year); !ok {
+			return fmt.Errorf("%w: unknown table %q", ErrInvalidArchive, table.Name)
+		}
+	}
+	return nil
+}
+
+// Restore recreates the year of an archive made by Dump, in one transaction. The year
+// must not exist; it comes back archived. Archives of an older schema restore too, the
+// columns added since then take their defaults.
+func (m ArchiveModel) Restore(archive *zip.Reader) (*ArchiveManifest, error) {
+	manifest, err := ReadManifest(archive)
+	if err != nil {
+		return nil, err
+	}
+	err = ensureMigrationsTable(m.DB)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	err = lockYear(ctx, tx, manifest.Year)
+	if err != nil {
+		return nil, err
+	}
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM years WHERE year = $1)`, manifest.Year).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrYearExists
+	}
+	err = provisionYear(ctx, tx, manifest.Year, false)
+	if err != nil {
+		return nil, err
+	}
+	err = restoreTables(ctx, tx, archive, YearTables, manifest.Tables, "_"+manifest.Year)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO years (year, archived_at) VALUES ($1, NOW())`, manifest.Year)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, tx.Commit()
+}
+
+// restoreTables loads the archived tables, named with suffix in the database, in the
+// order of tables; the order has to put referenced tables first. Tables missing from
+// the archive are left empty.
+func restoreTables(ctx context.Context, tx *sql.Tx, archive *zip.Reader, tables []string, archived []*ArchiveTable, suffix string) error {
+	for _, table := range tables {
+		for _, archivedTable := range archived {
+			if archivedTable.Name != table {
+				continue
+			}
+			err := restoreTable(ctx, tx, archive, table+suffix, archivedTable)
+			if err != nil {
+				return fmt.Errorf("restore %s: %w", table+suffix, err)
+			}
+		}
+	}
+	return nil
+}
+
+func restoreTable(ctx context.Context, tx *sql.Tx, archive *zip.Reader, name string, archived *ArchiveTable) error {
+	f, err := archive.Open(archived.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cr := csv.NewReader(f)
+	columns, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(name, columns...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	values := make([]interface{}, len(columns))
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, value := range record {
+			values[i] = value
+			if value == csvNull {
+				values[i] = nil
+			}
+		}
+		_, err = stmt.ExecContext(ctx, values...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	err = stmt.Close()
+	if err != nil {
+		return err
+	}
+	// serial columns continue after the restored rows.
+	for _, column := range columns {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		SELECT setval(seq, COALESCE((SELECT max(%[1]s) FROM %[2]s), 1), (SELECT count(*) > 0 FROM %[2]s))
+		FROM pg_get_serial_sequence($1, $2) AS seq
+		WHERE seq IS NOT NULL`, pq.QuoteIdentifier(column), name), name, column)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
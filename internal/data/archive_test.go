@@ -0,0 +1,90 @@
+package data
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testDB opens the database named by TEST_DATABASE_DSN, with the global migrations
+// applied. Tests using it create and drop years, so it must be a throwaway database;
+// they're skipped when it isn't set.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = MigrationModel{DB: db}.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	db := testDB(t)
+	models := NewModels(db)
+	const year = "2090_2091"
+	_, _, err := models.Years.Insert(&Year{Year: year}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { models.Years.Delete(year) })
+
+	_, err = db.Exec(fmt.Sprintf(`
+	INSERT INTO subjects_%s (subject_id, subject_name, subject_name_english, stage, semester, department,
+	max_theory_mark, max_lab_mark, max_semester_mark, max_final_exam, credits, active, ministerial)
+	VALUES (1, 'مادة', 'Subject', 'الاولى', 'الاول', 'القسم', 100, 0, 40, 60, 3, 'نعم', 'لا')`, year))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exam := &Exam{SubjectId: 1, Semester: "الاول", Date: "2091-01-15", StartTime: "09:00", EndTime: "11:30", Room: "القاعة 1"}
+	_, err = models.Exams.Insert(year, exam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := models.Exams.GetAll(year, "all", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = models.Archives.Dump(&buf, year)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = models.Years.Delete(year)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = models.Archives.Restore(archive)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	got, err := models.Exams.GetAll(year, "all", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("restored %d exams, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("restored exam = %+v, want %+v", *got[i], *want[i])
+		}
+	}
+}
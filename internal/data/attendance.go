@@ -0,0 +1,323 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"collegecm.hamid.net/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Attendance statuses. A student over the warning threshold of a subject gets a
+// warning (إنذار), over the ban threshold they're barred (حرمان) from its final exam.
+const (
+	AttendanceWarning = "إنذار"
+	AttendanceBan     = "حرمان"
+)
+
+// ErrNotEnrolled is returned when an absence is recorded for a student who doesn't
+// take the subject.
+var ErrNotEnrolled = errors.New("student not enrolled in subject")
+
+// AttendancePolicy holds the absence percentages at which students are warned and
+// barred.
+type AttendancePolicy struct {
+	WarningThreshold float64
+	BanThreshold     float64
+}
+
+// Status returns the attendance status for an absence percentage, or "" when neither
+// threshold is reached.
+func (p AttendancePolicy) Status(percentage float64) string {
+	switch {
+	case percentage >= p.BanThreshold:
+		return AttendanceBan
+	case percentage >= p.WarningThreshold:
+		return AttendanceWarning
+	default:
+		return ""
+	}
+}
+
+// AttendanceSession is one lecture of a subject, with the students who missed it.
+// Every other student taking the subject is recorded as present.
+type AttendanceSession struct {
+	SubjectId int64   `json:"subject_id"`
+	Date      string  `json:"date"`
+	Period    int     `json:"period"`
+	Absent    []int64 `json:"absent"`
+	// Recorded counts the students the session was recorded for.
+	Recorded int `json:"recorded"`
+}
+
+// AttendanceRecord is one student's row of a recorded lecture, the record audited for
+// attendance.
+type AttendanceRecord struct {
+	Id        int64  `json:"id"`
+	StudentId int64  `json:"student_id"`
+	SubjectId int64  `json:"subject_id"`
+	Date      string `json:"date"`
+	Period    int    `json:"period"`
+	Absent    bool   `json:"absent"`
+}
+
+// AttendanceChange is the outcome of recording one student's row with RecordSession.
+// Before is nil when the row was created.
+type AttendanceChange struct {
+	Before *AttendanceRecord
+	After  *AttendanceRecord
+}
+
+func ValidateAttendanceSession(v *validator.Validator, session *AttendanceSession) {
+	v.Check(session.SubjectId > 0, "المادة", "يجب تزويد المعلومات")
+	_, err := time.Parse(time.DateOnly, session.Date)
+	v.Check(err == nil, "التاريخ", "يجب ادخال تاريخ صحيح (YYYY-MM-DD)")
+	v.Check(session.Period >= 1 && session.Period <= 10, "المحاضرة", "يجب ان يكون بين 1 و 10")
+	seen := make(map[int64]bool, len(session.Absent))
+	for _, id := range session.Absent {
+		v.Check(!seen[id], "الغياب", "يوجد طالب مكرر")
+		seen[id] = true
+	}
+}
+
+// AttendanceSummary is a student's attendance in one subject.
+type AttendanceSummary struct {
+	StudentId   int64   `json:"student_id"`
+	StudentName string  `json:"student_name"`
+	SubjectId   int64   `json:"subject_id"`
+	SubjectName string  `json:"subject_name"`
+	Sessions    int     `json:"sessions"`
+	Absences    int     `json:"absences"`
+	Percentage  float64 `json:"percentage"`
+	Status      string  `json:"status"`
+}
+
+// AttendanceFilters narrows down the summaries; zero fields don't filter. Flagged keeps
+// the students with a warning or a ban.
+type AttendanceFilters struct {
+	SubjectId int64
+	StudentId int64
+	Status    string
+	Flagged   bool
+}
+
+type AttendanceModel struct {
+	DB *sql.DB
+}
+
+// RecordSession records a lecture for every student taking the subject: the students
+// of its stage and those carrying it over, less the exempted. Recording the same
+// lecture again replaces the absences. It returns the rows that were created or
+// changed, ErrRecordNotFound when the subject doesn't exist and ErrNotEnrolled when an
+// absent student doesn't take the subject.
+func (m AttendanceModel) RecordSession(year string, session *AttendanceSession) ([]*AttendanceChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if session.Absent == nil {
+		session.Absent = []int64{}
+	}
+	enrolled := fmt.Sprintf(`
+	SELECT s.student_id
+	FROM students_%[1]s s
+	JOIN subjects_%[1]s sub ON sub.subject_id = $1
+	WHERE s.stage = sub.stage
+	UNION
+	SELECT c.student_id FROM carryovers_%[1]s c WHERE c.subject_id = $1
+	EXCEPT
+	SELECT e.student_id FROM exempted_%[1]s e WHERE e.subject_id = $1`, year)
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM subjects_%s WHERE subject_id = $1)`, year), session.SubjectId).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+	var strays int
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`
+	SELECT count(*) FROM unnest($2::integer[]) AS absent(student_id)
+	WHERE student_id NOT IN (%s)`, enrolled), session.SubjectId, pq.Array(session.Absent)).Scan(&strays)
+	if err != nil {
+		return nil, err
+	}
+	if strays > 0 {
+		return nil, ErrNotEnrolled
+	}
+	previous, err := sessionRecords(ctx, tx, fmt.Sprintf(`
+	SELECT id, student_id, absent
+	FROM attendance_%s
+	WHERE subject_id = $1 AND session_date = $2 AND period = $3
+	FOR UPDATE`, year), session)
+	if err != nil {
+		return nil, err
+	}
+	before := make(map[int64]*AttendanceRecord, len(previous))
+	for _, record := range previous {
+		before[record.StudentId] = record
+	}
+	recorded, err := sessionRecords(ctx, tx, fmt.Sprintf(`
+	INSERT INTO attendance_%s (student_id, subject_id, session_date, period, absent)
+	SELECT enrolled.student_id, $1, $2, $3, enrolled.student_id = ANY($4::integer[])
+	FROM (%s) enrolled
+	ON CONFLICT (student_id, subject_id, session_date, period)
+	DO UPDATE SET absent = EXCLUDED.absent
+	RETURNING id, student_id, absent`, year, enrolled), session, pq.Array(session.Absent))
+	if err != nil {
+		return nil, err
+	}
+	session.Recorded = len(recorded)
+	var changes []*AttendanceChange
+	for _, record := range recorded {
+		prior := before[record.StudentId]
+		if prior != nil && prior.Absent == record.Absent {
+			continue
+		}
+		changes = append(changes, &AttendanceChange{Before: prior, After: record})
+	}
+	return changes, tx.Commit()
+}
+
+// DeleteSession removes a recorded lecture and returns its rows. It returns
+// ErrRecordNotFound when it wasn't recorded.
+func (m AttendanceModel) DeleteSession(year string, session *AttendanceSession) ([]*AttendanceRecord, error) {
+	query := fmt.Sprintf(`
+	DELETE FROM attendance_%s
+	WHERE subject_id = $1 AND session_date = $2 AND period = $3
+	RETURNING id, student_id, absent`, year)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	records, err := sessionRecords(ctx, m.DB, query, session)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return records, nil
+}
+
+// sessionRecords runs a query on the rows of the session, with its subject, date and
+// period as the first arguments, that returns the id, student_id and absent columns.
+func sessionRecords(ctx context.Context, db queryer, query string, session *AttendanceSession, args ...interface{}) ([]*AttendanceRecord, error) {
+	args = append([]interface{}{session.SubjectId, session.Date, session.Period}, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*AttendanceRecord
+	for rows.Next() {
+		record := AttendanceRecord{SubjectId: session.SubjectId, Date: session.Date, Period: session.Period}
+		err := rows.Scan(&record.Id, &record.StudentId, &record.Absent)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Summaries returns the attendance of every student in the subjects of the stage, as
+// far as access allows. The absence percentage is taken over the lectures recorded
+// for the student.
+func (m AttendanceModel) Summaries(year, stage string, access *SubjectAccess, policy AttendancePolicy, filters AttendanceFilters) ([]*AttendanceSummary, error) {
+	query := fmt.Sprintf(`
+	SELECT a.student_id, s.student_name, a.subject_id, sub.subject_name,
+	count(*), count(*) FILTER (WHERE a.absent)
+	FROM attendance_%[1]s a
+	JOIN students_%[1]s s ON a.student_id = s.student_id
+	JOIN subjects_%[1]s sub ON a.subject_id = sub.subject_id
+	WHERE TRUE`, year)
+	var args []interface{}
+	if stage != "all" {
+		args = append(args, stage)
+		query += fmt.Sprintf(" AND sub.stage = $%d", len(args))
+	}
+	if access != nil && !access.All {
+		args = append(args, pq.Array(access.SubjectIds))
+		query += fmt.Sprintf(" AND a.subject_id = ANY($%d)", len(args))
+	}
+	if filters.SubjectId != 0 {
+		args = append(args, filters.SubjectId)
+		query += fmt.Sprintf(" AND a.subject_id = $%d", len(args))
+	}
+	if filters.StudentId != 0 {
+		args = append(args, filters.StudentId)
+		query += fmt.Sprintf(" AND a.student_id = $%d", len(args))
+	}
+	query += `
+	GROUP BY a.student_id, s.student_name, a.subject_id, sub.subject_name
+	ORDER BY a.subject_id, s.student_name`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []*AttendanceSummary{}
+	for rows.Next() {
+		var summary AttendanceSummary
+		err := rows.Scan(
+			&summary.StudentId,
+			&summary.StudentName,
+			&summary.SubjectId,
+			&summary.SubjectName,
+			&summary.Sessions,
+			&summary.Absences,
+		)
+		if err != nil {
+			return nil, err
+		}
+		summary.grade(policy)
+		if filters.Status != "" && summary.Status != filters.Status || filters.Flagged && summary.Status == "" {
+			continue
+		}
+		summaries = append(summaries, &summary)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// Status returns the student's attendance status in the subject, "" when they have
+// no recorded lectures or neither threshold is reached.
+func (m AttendanceModel) Status(year string, studentId, subjectId int64, policy AttendancePolicy) (string, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*), count(*) FILTER (WHERE absent)
+	FROM attendance_%s
+	WHERE student_id = $1 AND subject_id = $2`, year)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var summary AttendanceSummary
+	err := m.DB.QueryRowContext(ctx, query, studentId, subjectId).Scan(&summary.Sessions, &summary.Absences)
+	if err != nil {
+		return "", err
+	}
+	summary.grade(policy)
+	return summary.Status, nil
+}
+
+func (s *AttendanceSummary) grade(policy AttendancePolicy) {
+	if s.Sessions == 0 {
+		return
+	}
+	s.Percentage = math.Round(float64(s.Absences)*10000/float64(s.Sessions)) / 100
+	s.Status = policy.Status(s.Percentage)
+}
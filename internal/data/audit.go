@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The kinds of write recorded in the audit log.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// AuditEntry is one row of the append-only audit log. Before is empty for creates and
+// After is empty for deletes. Year is "all" for the global tables.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	UserId    int64           `json:"user_id"`
+	Username  string          `json:"username"`
+	Year      string          `json:"year"`
+	TableName string          `json:"table_name"`
+	RecordId  int64           `json:"record_id"`
+	Action    string          `json:"action"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// AuditFilters narrows down AuditModel.GetAll. Zero values match everything.
+type AuditFilters struct {
+	UserId    int64
+	Year      string
+	TableName string
+	RecordId  int64
+	Action    string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// NewAuditEntry builds an entry with before and after encoded as JSON; either may be nil.
+func NewAuditEntry(userId int64, year, table string, recordId int64, action string, before, after interface{}) (*AuditEntry, error) {
+	entry := &AuditEntry{
+		UserId:    userId,
+		Year:      year,
+		TableName: table,
+		RecordId:  recordId,
+		Action:    action,
+	}
+	var err error
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		entry.After, err = json.Marshal(after)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+func (m AuditModel) Insert(entry *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return insertAudit(ctx, m.DB, entry)
+}
+
+// insertAudit writes the entry with db, so models can audit inside their transaction.
+func insertAudit(ctx context.Context, db rowQueryer, entry *AuditEntry) error {
+	query := `
+	INSERT INTO audit_log (user_id, year, table_name, record_id, action, before, after)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+	args := []interface{}{
+		entry.UserId,
+		entry.Year,
+		entry.TableName,
+		entry.RecordId,
+		entry.Action,
+		jsonColumn(entry.Before),
+		jsonColumn(entry.After),
+	}
+	return db.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAll returns the entries matching the filters, newest first.
+func (m AuditModel) GetAll(filters AuditFilters) ([]*AuditEntry, error) {
+	query := `
+	SELECT a.id, a.user_id, COALESCE(u.username, ''), a.year, a.table_name, a.record_id,
+	a.action, COALESCE(a.before::text, ''), COALESCE(a.after::text, ''), a.created_at
+	FROM audit_log a
+	LEFT JOIN users u ON a.user_id = u.id
+	WHERE 1 = 1`
+	var args []interface{}
+	if filters.UserId > 0 {
+		args = append(args, filters.UserId)
+		query += fmt.Sprintf(" AND a.user_id = $%d", len(args))
+	}
+	if filters.Year != "" {
+		args = append(args, filters.Year)
+		query += fmt.Sprintf(" AND a.year = $%d", len(args))
+	}
+	if filters.TableName != "" {
+		args = append(args, filters.TableName)
+		query += fmt.Sprintf(" AND a.table_name = $%d", len(args))
+	}
+	if filters.RecordId > 0 {
+		args = append(args, filters.RecordId)
+		query += fmt.Sprintf(" AND a.record_id = $%d", len(args))
+	}
+	if filters.Action != "" {
+		args = append(args, filters.Action)
+		query += fmt.Sprintf(" AND a.action = $%d", len(args))
+	}
+	if !filters.From.IsZero() {
+		args = append(args, filters.From)
+		query += fmt.Sprintf(" AND a.created_at >= $%d", len(args))
+	}
+	if !filters.To.IsZero() {
+		args = append(args, filters.To)
+		query += fmt.Sprintf(" AND a.created_at < $%d", len(args))
+	}
+	query += " ORDER BY a.created_at DESC, a.id DESC"
+	if filters.Limit > 0 {
+		args = append(args, filters.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after string
+		err := rows.Scan(
+			&entry.ID,
+			&entry.UserId,
+			&entry.Username,
+			&entry.Year,
+			&entry.TableName,
+			&entry.RecordId,
+			&entry.Action,
+			&before,
+			&after,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if before != "" {
+			entry.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			entry.After = json.RawMessage(after)
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// jsonColumn converts raw JSON into a value lib/pq can store in a JSONB column; a
+// []byte would be sent as bytea.
+func jsonColumn(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
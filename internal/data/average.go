@@ -0,0 +1,158 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// SubjectResult is one graded subject in a student's yearly breakdown.
+type SubjectResult struct {
+	SubjectId   int64   `json:"subject_id"`
+	SubjectName string  `json:"subject_name"`
+	Department  string  `json:"department"`
+	Credits     int     `json:"credits"`
+	Carryover   bool    `json:"carryover"`
+	Round       int     `json:"round"`
+	Total       int     `json:"total"`
+	Percentage  float64 `json:"percentage"`
+	Passed      bool    `json:"passed"`
+	Grade       string  `json:"grade"`
+}
+
+// StudentAverage is a student's credit-weighted average (معدل) for one year.
+type StudentAverage struct {
+	StudentId   int64            `json:"student_id"`
+	StudentName string           `json:"student_name"`
+	Stage       string           `json:"stage"`
+	State       string           `json:"state"`
+	Average     float64          `json:"average"`
+	Credits     int              `json:"credits"`
+	Failed      int              `json:"failed"`
+	Subjects    []*SubjectResult `json:"subjects"`
+}
+
+type AverageModel struct {
+	DB *sql.DB
+}
+
+// GetAll computes the weighted average of every student of the stage who has marks in
+// the year. Exempted subjects are left out entirely; carryover subjects are counted
+// like any other subject and flagged in the breakdown. Subjects without a mark row
+// aren't counted.
+func (m AverageModel) GetAll(year, stage string, policy GradePolicy) ([]*StudentAverage, error) {
+	return m.get(year, stage, "", policy)
+}
+
+// GetByDepartment is like GetAll but only counts subjects of the given department.
+func (m AverageModel) GetByDepartment(year, stage, department string, policy GradePolicy) ([]*StudentAverage, error) {
+	return m.get(year, stage, department, policy)
+}
+
+func (m AverageModel) get(year, stage, department string, policy GradePolicy) ([]*StudentAverage, error) {
+	if strings.TrimSpace(year) == "" {
+		return nil, errors.New("invalid year")
+	}
+	marksTable := fmt.Sprintf("marks_%s", year)
+	studentsTable := fmt.Sprintf("students_%s", year)
+	subjectsTable := fmt.Sprintf("subjects_%s", year)
+	exemptedTable := fmt.Sprintf("exempted_%s", year)
+	carryoversTable := fmt.Sprintf("carryovers_%s", year)
+	query := fmt.Sprintf(`
+	SELECT
+	st.student_id, st.student_name, st.stage, st.state,
+	sub.subject_id, sub.subject_name, sub.department, sub.credits,
+	m.semester_mark, sub.max_semester_mark, m.final_mark, sub.max_final_exam, m.second_final_mark,
+	c.id IS NOT NULL AS carryover
+	FROM %s m
+	JOIN %s st ON m.student_id = st.student_id
+	JOIN %s sub ON m.subject_id = sub.subject_id
+	LEFT JOIN %s e ON e.student_id = m.student_id AND e.subject_id = m.subject_id
+	LEFT JOIN %s c ON c.student_id = m.student_id AND c.subject_id = m.subject_id
+	WHERE e.id IS NULL
+	`, marksTable, studentsTable, subjectsTable, exemptedTable, carryoversTable)
+	var args []interface{}
+	if stage != "all" {
+		args = append(args, stage)
+		query += fmt.Sprintf(" AND st.stage = $%d", len(args))
+	}
+	if department != "" {
+		args = append(args, department)
+		query += fmt.Sprintf(" AND sub.department = $%d", len(args))
+	}
+	query += " ORDER BY st.student_id, sub.subject_id"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var averages []*StudentAverage
+	var current *StudentAverage
+	for rows.Next() {
+		var student StudentAverage
+		var mark Mark
+		var result SubjectResult
+		err := rows.Scan(
+			&student.StudentId,
+			&student.StudentName,
+			&student.Stage,
+			&student.State,
+			&result.SubjectId,
+			&result.SubjectName,
+			&result.Department,
+			&result.Credits,
+			&mark.SemesterMark,
+			&mark.MaxSemesterMark,
+			&mark.FinalMark,
+			&mark.MaxFinalExam,
+			&mark.SecondFinalMark,
+			&result.Carryover,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.StudentId != student.StudentId {
+			current = &student
+			current.Subjects = []*SubjectResult{}
+			averages = append(averages, current)
+		}
+		policy.Grade(&mark)
+		result.Round = mark.Round
+		result.Total = mark.Total
+		result.Percentage = mark.Percentage
+		result.Passed = mark.Passed
+		result.Grade = mark.Grade
+		current.Subjects = append(current.Subjects, &result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, average := range averages {
+		average.compute()
+	}
+	return averages, nil
+}
+
+// compute derives Average, Credits and Failed from the subject breakdown.
+func (a *StudentAverage) compute() {
+	var weighted float64
+	a.Credits, a.Failed = 0, 0
+	for _, result := range a.Subjects {
+		if !result.Passed {
+			a.Failed++
+		}
+		weighted += result.Percentage * float64(result.Credits)
+		a.Credits += result.Credits
+	}
+	a.Average = 0
+	if a.Credits > 0 {
+		a.Average = math.Round(weighted*100/float64(a.Credits)) / 100
+	}
+}
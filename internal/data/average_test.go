@@ -0,0 +1,46 @@
+package data
+
+import "testing"
+
+func TestStudentAverageCompute(t *testing.T) {
+	tests := []struct {
+		name     string
+		subjects []*SubjectResult
+		average  float64
+		credits  int
+		failed   int
+	}{
+		{"no subjects", []*SubjectResult{}, 0, 0, 0},
+		{"one subject", []*SubjectResult{
+			{Percentage: 72.5, Credits: 3, Passed: true},
+		}, 72.5, 3, 0},
+		{"weighted by credits", []*SubjectResult{
+			{Percentage: 80, Credits: 3, Passed: true},
+			{Percentage: 60, Credits: 1, Passed: true},
+		}, 75, 4, 0},
+		{"failed subjects count", []*SubjectResult{
+			{Percentage: 90, Credits: 2, Passed: true},
+			{Percentage: 40, Credits: 2, Passed: false},
+			{Percentage: 30, Credits: 1, Passed: false},
+		}, 58, 5, 2},
+		{"rounded to two places", []*SubjectResult{
+			{Percentage: 70, Credits: 1, Passed: true},
+			{Percentage: 71, Credits: 2, Passed: true},
+		}, 70.67, 3, 0},
+		{"subjects without credits", []*SubjectResult{
+			{Percentage: 80, Credits: 0, Passed: true},
+			{Percentage: 20, Credits: 0, Passed: false},
+		}, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// stale values are overwritten.
+			average := &StudentAverage{Average: 99, Credits: 99, Failed: 99, Subjects: tt.subjects}
+			average.compute()
+			if average.Average != tt.average || average.Credits != tt.credits || average.Failed != tt.failed {
+				t.Errorf("average %v credits %d failed %d, want average %v credits %d failed %d",
+					average.Average, average.Credits, average.Failed, tt.average, tt.credits, tt.failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package data
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"collegecm.hamid.net/internal/validator"
+)
+
+// BackupFormat is the version of the backup layout written by BackupModel.Dump. It's
+// raised whenever a change would keep older binaries from restoring a backup.
+const BackupFormat = 1
+
+// backupTables are the global tables a backup holds, in an order that puts referenced
+// tables first. sessions are left out, restoring logs everyone out.
+var backupTables = []string{"users", "roles", "role_grants", "tables", "years", "privileges", "user_roles", "locks", "audit_log"}
+
+// BackupManifest describes a full backup: a zip holding manifest.json, the global
+// tables under global/ and the tables of each year under years/<year>/, one CSV file
+// with a header row per table.
+type BackupManifest struct {
+	Format    int                `json:"format"`
+	CreatedAt time.Time          `json:"created_at"`
+	Migration int64              `json:"migration"`
+	Tables    []*ArchiveTable    `json:"tables"`
+	Years     []*ArchiveManifest `json:"years"`
+}
+
+type BackupModel struct {
+	DB *sql.DB
+}
+
+// Dump writes every global table and the tables of every year to w, all read in one
+// snapshot.
+func (m BackupModel) Dump(w io.Writer) (*BackupManifest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	manifest := &BackupManifest{Format: BackupFormat, CreatedAt: time.Now().UTC()}
+	manifest.Migration, err = latestMigration(ctx, tx, GlobalScope)
+	if err != nil {
+		return nil, err
+	}
+	global, err := loadMigrations(".")
+	if err != nil {
+		return nil, err
+	}
+	if len(global) > 0 && manifest.Migration < global[len(global)-1].Version {
+		return nil, fmt.Errorf("backup: the database is at global migration %d, apply the pending migrations first", manifest.Migration)
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT year FROM years ORDER BY year`)
+	if err != nil {
+		return nil, err
+	}
+	var years []string
+	for rows.Next() {
+		var year string
+		if err := rows.Scan(&year); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		years = append(years, year)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	zw := zip.NewWriter(w)
+	manifest.Tables, err = dumpTables(ctx, tx, zw, "global", backupTables, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, year := range years {
+		yearManifest := &ArchiveManifest{Year: year, CreatedAt: manifest.CreatedAt}
+		yearManifest.Migration, err = latestMigration(ctx, tx, year)
+		if err != nil {
+			return nil, err
+		}
+		yearManifest.Tables, err = dumpTables(ctx, tx, zw, path.Join("years", year), YearTables, "_"+year)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Years = append(manifest.Years, yearManifest)
+	}
+	err = writeManifest(zw, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, zw.Close()
+}
+
+// ReadBackupManifest returns the manifest of a backup made by Dump. It fails for
+// backups taken with a newer schema than this binary knows about.
+func ReadBackupManifest(archive *zip.Reader) (*BackupManifest, error) {
+	var manifest BackupManifest
+	err := readManifest(archive, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Format != BackupFormat {
+		return nil, fmt.Errorf("%w: unsupported backup format %d", ErrInvalidArchive, manifest.Format)
+	}
+	for _, table := range manifest.Tables {
+		if !validator.In(table.Name, backupTables...) {
+			return nil, fmt.Errorf("%w: unknown table %q", ErrInvalidArchive, table.Name)
+		}
+	}
+	global, err := loadMigrations(".")
+	if err != nil {
+		return nil, err
+	}
+	yearly, err := loadMigrations("year")
+	if err != nil {
+		return nil, err
+	}
+	if len(global) > 0 && manifest.Migration > global[len(global)-1].Version {
+		return nil, fmt.Errorf("%w: backup needs global migration %d, this binary has up to %d", ErrInvalidArchive, manifest.Migration, global[len(global)-1].Version)
+	}
+	for _, year := range manifest.Years {
+		err = year.validate()
+		if err != nil {
+			return nil, err
+		}
+		if len(yearly) > 0 && year.Migration > yearly[len(yearly)-1].Version {
+			return nil, fmt.Errorf("%w: backup of %s needs year migration %d, this binary has up to %d", ErrInvalidArchive, year.Year, year.Migration, yearly[len(yearly)-1].Version)
+		}
+	}
+	return &manifest, nil
+}
+
+// Restore loads a backup made by Dump into an empty database, in one transaction. The
+// schema is created by the migrations this binary embeds, so a backup of an older
+// schema is upgraded on the way in.
+func (m BackupModel) Restore(archive *zip.Reader) (*BackupManifest, error) {
+	manifest, err := ReadBackupManifest(archive)
+	if err != nil {
+		return nil, err
+	}
+	err = ensureMigrationsTable(m.DB)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	err = ensureEmpty(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	global, err := loadMigrations(".")
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrationsTx(ctx, tx, GlobalScope)
+	if err != nil {
+		return nil, err
+	}
+	for _, migration := range global {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err = execMigration(ctx, tx, GlobalScope, migration, true, nil)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s %06d_%s: %w", GlobalScope, migration.Version, migration.Name, err)
+		}
+	}
+	for _, year := range manifest.Years {
+		err = provisionYear(ctx, tx, year.Year, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the migrations seed rows that the backup holds as well, the backup's are kept
+	// so the ids the tables refer to each other by stay the same.
+	var restored []string
+	for _, table := range manifest.Tables {
+		restored = append(restored, table.Name)
+	}
+	if len(restored) > 0 {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`TRUNCATE %s RESTART IDENTITY CASCADE`, strings.Join(restored, ", ")))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = restoreTables(ctx, tx, archive, backupTables, manifest.Tables, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, year := range manifest.Years {
+		err = restoreTables(ctx, tx, archive, YearTables, year.Tables, "_"+year.Year)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, tx.Commit()
+}
+
+// ensureEmpty fails unless the database has no users and no years, so a restore
+// doesn't mix with live data. A database with only the schema migrated is empty.
+func ensureEmpty(ctx context.Context, tx *sql.Tx) error {
+	for _, table := range []string{"users", "years"} {
+		var exists, rows bool
+		err := tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s)`, table)).Scan(&rows)
+		if err != nil {
+			return err
+		}
+		if rows {
+			return fmt.Errorf("restore: the database isn't empty, %s has rows", table)
+		}
+	}
+	return nil
+}
+
+// latestMigration returns the version of the newest migration applied to scope.
+func latestMigration(ctx context.Context, db queryer, scope string) (int64, error) {
+	applied, err := appliedMigrationsTx(ctx, db, scope)
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for version := range applied {
+		latest = max(latest, version)
+	}
+	return latest, nil
+}
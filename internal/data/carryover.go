@@ -51,50 +51,64 @@ func (m CarryoverModel) Insert(year string, carryover *Carryover) error {
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&carryover.Id, &carryover.CreatedAt)
 }
 
-// ddd
-func (m CarryoverModel) GetAll(year, stage string) ([]*Carryover, error) {
+// GetAll lists the carryovers of the stage's students that match the filters, a page at
+// a time. Name matches the student or the subject name.
+func (m CarryoverModel) GetAll(year, stage string, filters Filters) ([]*Carryover, Metadata, error) {
 	if strings.TrimSpace(year) == "" {
-		return nil, errors.New("invalid year")
+		return nil, Metadata{}, errors.New("invalid year")
 	}
 	carryoversTable := fmt.Sprintf("carryovers_%s", year)
 	studentsTable := fmt.Sprintf("students_%s", year)
 	subjectsTable := fmt.Sprintf("subjects_%s", year)
 	query := fmt.Sprintf(`
-		SELECT c.id, s.student_name AS student_name, sub.subject_name AS subject_name
+		SELECT count(*) OVER(), c.id, s.student_name AS student_name, sub.subject_name AS subject_name
 		FROM %s c
 		JOIN %s s ON c.student_id = s.student_id
 		JOIN %s sub ON c.subject_id = sub.subject_id
+		WHERE 1 = 1
 	`, carryoversTable, studentsTable, subjectsTable)
 	var args []interface{}
 	if stage != "all" {
-		query += " WHERE s.stage = $1"
 		args = append(args, stage)
+		query += fmt.Sprintf(" AND s.stage = $%d", len(args))
+	}
+	columns := filterColumns{
+		name:       []string{"s.student_name", "sub.subject_name"},
+		state:      "s.state",
+		department: "sub.department",
+		semester:   "sub.semester",
 	}
+	conditions, args := filters.conditions(columns, args)
+	query += conditions + filters.orderBy("id")
+	limit, args := filters.limitOffset(args)
+	query += limit
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	var carryovers []*Carryover
 	for rows.Next() {
 		var carryover Carryover
 		err := rows.Scan(
+			&totalRecords,
 			&carryover.Id,
 			&carryover.StudentName,
 			&carryover.SubjectName,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		carryovers = append(carryovers, &carryover)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return carryovers, nil
+	return carryovers, calculateMetadata(totalRecords, filters), nil
 }
 
 func (m CarryoverModel) Get(year string, id int64) (*Carryover, error) {
@@ -108,16 +122,18 @@ func (m CarryoverModel) Get(year string, id int64) (*Carryover, error) {
 	studentsTable := fmt.Sprintf("students_%s", year)
 	subjectsTable := fmt.Sprintf("subjects_%s", year)
 	query := fmt.Sprintf(`
-	SELECT c.id, s.name AS student_name, sub.name AS subject_name
+	SELECT c.id, c.student_id, c.subject_id, s.student_name AS student_name, sub.subject_name AS subject_name
 	FROM %s c
-	JOIN %s s ON c.student_id = s.id
-	JOIN %s sub ON c.subject.id = sub.id
+	JOIN %s s ON c.student_id = s.student_id
+	JOIN %s sub ON c.subject_id = sub.subject_id
 	WHERE c.id = $1;`, carryoversTable, studentsTable, subjectsTable)
 	var carryover Carryover
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&carryover.Id,
+		&carryover.StudentId,
+		&carryover.SubjectId,
 		&carryover.StudentName,
 		&carryover.SubjectName,
 	)
@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ScopeConsistency lists how the years table, the tables catalogue and the tables that
+// actually exist in the database disagree for one year, or for the global tables when
+// Scope is GlobalScope.
+type ScopeConsistency struct {
+	Scope string `json:"scope"`
+	// Registered tells whether the year is listed in the years table.
+	Registered bool `json:"registered"`
+	// MissingTables are the year tables that don't exist, MissingRows the tables
+	// without a row in the tables catalogue, StaleRows the catalogue rows of tables
+	// that don't exist and DuplicateRows the tables listed more than once.
+	MissingTables []string `json:"missing_tables"`
+	MissingRows   []string `json:"missing_rows"`
+	StaleRows     []string `json:"stale_rows"`
+	DuplicateRows []string `json:"duplicate_rows"`
+	// Repair is what a repair does, or did, about it: "provision" creates the missing
+	// tables and registers the year, "clear" removes the catalogue rows of a year that
+	// has no tables left, and "catalogue" fixes the catalogue rows only.
+	Repair string `json:"repair"`
+}
+
+type ConsistencyReport struct {
+	Consistent bool                `json:"consistent"`
+	Scopes     []*ScopeConsistency `json:"scopes"`
+}
+
+type ConsistencyModel struct {
+	DB *sql.DB
+}
+
+// Check compares the years table and the tables catalogue with the tables in the
+// database. Only the scopes with a mismatch are reported.
+func (m ConsistencyModel) Check() (*ConsistencyReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	registered, err := stringSet(ctx, m.DB, `SELECT year FROM years`)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := stringSet(ctx, m.DB, `SELECT tablename FROM pg_tables WHERE schemaname = current_schema()`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.DB.QueryContext(ctx, `SELECT table_name, count(*) FROM tables GROUP BY table_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	listed := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		listed[name] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	years := make(map[string]bool)
+	for year := range registered {
+		years[year] = true
+	}
+	for _, names := range []map[string]int{existing, listed} {
+		for name := range names {
+			if _, year, ok := splitYearTable(name); ok {
+				years[year] = true
+			}
+		}
+	}
+	report := &ConsistencyReport{Consistent: true, Scopes: []*ScopeConsistency{}}
+	global := &ScopeConsistency{Scope: GlobalScope, Registered: true, Repair: "catalogue"}
+	for _, name := range GlobalTables {
+		if listed[name] == 0 {
+			global.MissingRows = append(global.MissingRows, name)
+		}
+	}
+	for name, count := range listed {
+		if _, _, ok := splitYearTable(name); !ok && count > 1 {
+			global.DuplicateRows = append(global.DuplicateRows, name)
+		}
+	}
+	sort.Strings(global.DuplicateRows)
+	if !global.consistent() {
+		report.Scopes = append(report.Scopes, global)
+	}
+
+	var order []string
+	for year := range years {
+		order = append(order, year)
+	}
+	sort.Strings(order)
+	for _, year := range order {
+		scope := &ScopeConsistency{Scope: year, Registered: registered[year] > 0}
+		var found bool
+		for _, table := range YearTables {
+			name := table + "_" + year
+			_, exists := existing[name]
+			found = found || exists
+			switch {
+			case !exists && listed[name] > 0:
+				scope.StaleRows = append(scope.StaleRows, name)
+			case exists && listed[name] == 0:
+				scope.MissingRows = append(scope.MissingRows, name)
+			}
+			if !exists {
+				scope.MissingTables = append(scope.MissingTables, name)
+			}
+			if listed[name] > 1 {
+				scope.DuplicateRows = append(scope.DuplicateRows, name)
+			}
+		}
+		switch {
+		case scope.Registered && len(scope.MissingTables) == 0 && len(scope.MissingRows) == 0:
+			scope.Repair = "catalogue"
+		case scope.Registered || found:
+			// tables left over from an interrupted create or delete may hold data, so
+			// the year is completed rather than dropped.
+			scope.Repair = "provision"
+		default:
+			scope.Repair = "clear"
+		}
+		if !scope.consistent() {
+			report.Scopes = append(report.Scopes, scope)
+		}
+	}
+	report.Consistent = len(report.Scopes) == 0
+	return report, nil
+}
+
+// Repair fixes every mismatch Check finds, each scope in its own transaction, and
+// returns the report it worked from.
+func (m ConsistencyModel) Repair() (*ConsistencyReport, error) {
+	err := ensureMigrationsTable(m.DB)
+	if err != nil {
+		return nil, err
+	}
+	report, err := m.Check()
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range report.Scopes {
+		err = m.repair(scope)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func (m ConsistencyModel) repair(scope *ScopeConsistency) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if scope.Scope != GlobalScope {
+		err = lockYear(ctx, tx, scope.Scope)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range scope.DuplicateRows {
+		err = dedupeTableRows(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+	}
+	switch scope.Repair {
+	case "provision":
+		err = provisionYear(ctx, tx, scope.Scope, true)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO years (year) VALUES ($1) ON CONFLICT (year) DO NOTHING`, scope.Scope)
+	case "clear":
+		_, err = tx.ExecContext(ctx, `
+		DELETE FROM privileges
+		WHERE table_id IN (SELECT id FROM tables WHERE table_name = ANY($1))`, pq.Array(scope.StaleRows))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM tables WHERE table_name = ANY($1)`, pq.Array(scope.StaleRows))
+	default:
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO tables (table_name)
+		SELECT name FROM unnest($1::text[]) AS missing(name)
+		WHERE NOT EXISTS (SELECT 1 FROM tables WHERE table_name = missing.name)`, pq.Array(scope.MissingRows))
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *ScopeConsistency) consistent() bool {
+	return s.Registered && len(s.MissingTables) == 0 && len(s.MissingRows) == 0 &&
+		len(s.StaleRows) == 0 && len(s.DuplicateRows) == 0
+}
+
+// dedupeTableRows keeps the oldest tables row named name, moving the privileges
+// granted on the others over to it.
+func dedupeTableRows(ctx context.Context, tx *sql.Tx, name string) error {
+	queries := []string{`
+	INSERT INTO privileges (user_id, year, table_id, stage, subject_id, can_read, can_write)
+	SELECT p.user_id, p.year, keep.id, p.stage, p.subject_id, p.can_read, p.can_write
+	FROM privileges p
+	JOIN tables t ON p.table_id = t.id
+	JOIN (SELECT min(id) AS id FROM tables WHERE table_name = $1) keep ON t.id <> keep.id
+	WHERE t.table_name = $1
+	ON CONFLICT (user_id, year, table_id, stage, subject_id) DO NOTHING`, `
+	DELETE FROM privileges
+	WHERE table_id IN (
+		SELECT id FROM tables
+		WHERE table_name = $1 AND id <> (SELECT min(id) FROM tables WHERE table_name = $1)
+	)`, `
+	DELETE FROM tables
+	WHERE table_name = $1 AND id <> (SELECT min(id) FROM tables WHERE table_name = $1)`,
+	}
+	for _, query := range queries {
+		_, err := tx.ExecContext(ctx, query, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitYearTable splits a year table name such as marks_2024_2025 into marks and
+// 2024_2025.
+func splitYearTable(name string) (string, string, bool) {
+	const yearLength = len("2024_2025")
+	if len(name) <= yearLength+1 || name[len(name)-yearLength-1] != '_' {
+		return "", "", false
+	}
+	table, year := name[:len(name)-yearLength-1], name[len(name)-yearLength:]
+	for _, yearTable := range YearTables {
+		if table == yearTable && isValidAcademicYear(year) {
+			return table, year, true
+		}
+	}
+	return "", "", false
+}
+
+// stringSet runs a query returning a single text column and counts each value.
+func stringSet(ctx context.Context, db *sql.DB, query string) (map[string]int, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	set := make(map[string]int)
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		set[value]++
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
@@ -38,7 +38,7 @@ func (c CustomModel) GetStudentData(year string, id int64, privs *CustomPrivileg
 	WHERE e.student_id = $1;`, exemptedTablename, subjectsTablename)
 	marksQ := fmt.Sprintf(`
 	SELECT
-	m.id, s.subject_name, s.max_semester_mark, m.semester_mark, s.max_final_exam, m.final_mark
+	m.id, m.subject_id, s.subject_name, s.max_semester_mark, m.semester_mark, s.max_final_exam, m.final_mark, m.second_final_mark
 	FROM %s m
 	JOIN %s s ON m.subject_id = s.subject_id
 	WHERE m.student_id = $1;`, marksTablename, subjectsTablename)
@@ -82,6 +82,7 @@ func (c CustomModel) GetStudentData(year string, id int64, privs *CustomPrivileg
 		if err != nil {
 			return nil, err
 		}
+		marks = filterMarks(marks, privs.MarkSubjects)
 	}
 	// Fetch subjects by stage
 	if privs.Subjects {
@@ -192,7 +193,7 @@ func fetchMarks(ctx context.Context, db *sql.DB, query string, id int64) ([]*Mar
 	var marks []*Mark
 	for rows.Next() {
 		var mark Mark
-		if err := rows.Scan(&mark.Id, &mark.SubjectName, &mark.MaxSemesterMark, &mark.SemesterMark, &mark.MaxFinalExam, &mark.FinalMark); err != nil {
+		if err := rows.Scan(&mark.Id, &mark.SubjectId, &mark.SubjectName, &mark.MaxSemesterMark, &mark.SemesterMark, &mark.MaxFinalExam, &mark.FinalMark, &mark.SecondFinalMark); err != nil {
 			return nil, err
 		}
 		marks = append(marks, &mark)
@@ -200,3 +201,14 @@ func fetchMarks(ctx context.Context, db *sql.DB, query string, id int64) ([]*Mar
 
 	return marks, rows.Err()
 }
+
+// filterMarks keeps the marks of the subjects covered by access.
+func filterMarks(marks []*Mark, access *SubjectAccess) []*Mark {
+	var allowed []*Mark
+	for _, mark := range marks {
+		if access.Allows(mark.SubjectId) {
+			allowed = append(allowed, mark)
+		}
+	}
+	return allowed
+}
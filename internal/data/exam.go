@@ -0,0 +1,344 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"collegecm.hamid.net/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Kinds of exam conflict. Two exams of the same stage or in the same room can't share
+// a slot; a carryover conflict, a student sitting exams of two stages at once, is
+// reported but allowed, it's often unavoidable.
+const (
+	ExamConflictStage     = "stage"
+	ExamConflictRoom      = "room"
+	ExamConflictCarryover = "carryover"
+)
+
+var (
+	// ErrDuplicateExam is returned when the subject already has an exam in the semester.
+	ErrDuplicateExam = errors.New("duplicate exam")
+	// ErrExamConflict is returned when an exam would share its slot with another exam of
+	// the same stage or room.
+	ErrExamConflict = errors.New("exam conflict")
+)
+
+// examTime is the layout of the exam start and end times.
+const examTime = "15:04"
+
+// Exam is a final exam session of a subject. Date is YYYY-MM-DD, the times HH:MM.
+type Exam struct {
+	ID          int64  `json:"id"`
+	SubjectId   int64  `json:"subject_id"`
+	SubjectName string `json:"subject_name"`
+	Stage       string `json:"stage"`
+	Semester    string `json:"semester"`
+	Date        string `json:"date"`
+	StartTime   string `json:"start_time"`
+	EndTime     string `json:"end_time"`
+	Room        string `json:"room"`
+}
+
+func ValidateExam(v *validator.Validator, exam *Exam) {
+	v.Check(exam.SubjectId > 0, "المادة", "يجب تزويد المعلومات")
+	v.Check(exam.Semester != "", "الفصل", "يجب تزويد المعلومات")
+	v.Check(len([]rune(exam.Semester)) <= 30, "الفصل", "يجب ان لا يزيد عن 30 حرف")
+	_, err := time.Parse(time.DateOnly, exam.Date)
+	v.Check(err == nil, "التاريخ", "يجب ادخال تاريخ صحيح (YYYY-MM-DD)")
+	start, startErr := time.Parse(examTime, exam.StartTime)
+	v.Check(startErr == nil, "وقت البدء", "يجب ادخال وقت صحيح (HH:MM)")
+	end, endErr := time.Parse(examTime, exam.EndTime)
+	v.Check(endErr == nil, "وقت الانتهاء", "يجب ادخال وقت صحيح (HH:MM)")
+	if startErr == nil && endErr == nil {
+		v.Check(start.Before(end), "وقت الانتهاء", "يجب ان يكون بعد وقت البدء")
+	}
+	v.Check(strings.TrimSpace(exam.Room) != "", "القاعة", "يجب تزويد المعلومات")
+	v.Check(len([]rune(exam.Room)) <= 100, "القاعة", "يجب ان لا يزيد عن 100 حرف")
+}
+
+// ExamConflict is a pair of exams whose slots overlap on the same day. Stage is set
+// for stage conflicts, Room for room conflicts and Students, the names of the students
+// taking both subjects, for carryover conflicts.
+type ExamConflict struct {
+	Kind             string   `json:"kind"`
+	ExamId           int64    `json:"exam_id"`
+	SubjectName      string   `json:"subject_name"`
+	OtherExamId      int64    `json:"other_exam_id"`
+	OtherSubjectName string   `json:"other_subject_name"`
+	Date             string   `json:"date"`
+	Stage            string   `json:"stage,omitempty"`
+	Room             string   `json:"room,omitempty"`
+	Students         []string `json:"students,omitempty"`
+}
+
+// Blocking reports whether the conflict keeps the timetable from being saved.
+func (c *ExamConflict) Blocking() bool {
+	return c.Kind != ExamConflictCarryover
+}
+
+type ExamModel struct {
+	DB *sql.DB
+}
+
+// examColumns selects an Exam from exams e joined with subjects sub.
+const examColumns = `e.id, e.subject_id, sub.subject_name, sub.stage, e.semester,
+	to_char(e.exam_date, 'YYYY-MM-DD'), to_char(e.start_time, 'HH24:MI'), to_char(e.end_time, 'HH24:MI'), e.room`
+
+func scanExam(row interface{ Scan(...interface{}) error }, exam *Exam) error {
+	return row.Scan(
+		&exam.ID,
+		&exam.SubjectId,
+		&exam.SubjectName,
+		&exam.Stage,
+		&exam.Semester,
+		&exam.Date,
+		&exam.StartTime,
+		&exam.EndTime,
+		&exam.Room,
+	)
+}
+
+// Insert adds the exam to the timetable, see save.
+func (m ExamModel) Insert(year string, exam *Exam) ([]*ExamConflict, error) {
+	return m.save(year, exam, fmt.Sprintf(`
+	INSERT INTO exams_%s (subject_id, semester, exam_date, start_time, end_time, room)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`, year), exam.SubjectId, exam.Semester, exam.Date, exam.StartTime, exam.EndTime, exam.Room)
+}
+
+// Update moves the exam to its new slot and room, see save.
+func (m ExamModel) Update(year string, exam *Exam) ([]*ExamConflict, error) {
+	return m.save(year, exam, fmt.Sprintf(`
+	UPDATE exams_%s
+	SET semester = $2, exam_date = $3, start_time = $4, end_time = $5, room = $6
+	WHERE id = $1
+	RETURNING id`, year), exam.ID, exam.Semester, exam.Date, exam.StartTime, exam.EndTime, exam.Room)
+}
+
+// save writes the exam and checks the timetable in one transaction, holding a lock
+// on the year's timetable so two writes can't both take the same slot. When the exam
+// has a blocking conflict nothing is saved and the conflicts are returned with
+// ErrExamConflict; otherwise the carryover conflicts are returned. The exam is
+// reloaded with its subject.
+func (m ExamModel) save(year string, exam *Exam, query string, args ...interface{}) ([]*ExamConflict, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('exams:' || $1))`, year)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&exam.ID)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code == "23505":
+			return nil, ErrDuplicateExam
+		default:
+			return nil, err
+		}
+	}
+	conflicts, err := examConflicts(ctx, tx, year, "", exam.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, conflict := range conflicts {
+		if conflict.Blocking() {
+			return conflicts, ErrExamConflict
+		}
+	}
+	err = scanExam(tx.QueryRowContext(ctx, fmt.Sprintf(`
+	SELECT %[2]s
+	FROM exams_%[1]s e
+	JOIN subjects_%[1]s sub ON e.subject_id = sub.subject_id
+	WHERE e.id = $1`, year, examColumns), exam.ID), exam)
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, tx.Commit()
+}
+
+func (m ExamModel) Get(year string, id int64) (*Exam, error) {
+	query := fmt.Sprintf(`
+	SELECT %[2]s
+	FROM exams_%[1]s e
+	JOIN subjects_%[1]s sub ON e.subject_id = sub.subject_id
+	WHERE e.id = $1`, year, examColumns)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var exam Exam
+	err := scanExam(m.DB.QueryRowContext(ctx, query, id), &exam)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &exam, nil
+}
+
+// GetAll returns the timetable of the stage in the order the exams are sat, optionally
+// only that of one semester.
+func (m ExamModel) GetAll(year, stage, semester string) ([]*Exam, error) {
+	query := fmt.Sprintf(`
+	SELECT %[2]s
+	FROM exams_%[1]s e
+	JOIN subjects_%[1]s sub ON e.subject_id = sub.subject_id
+	WHERE TRUE`, year, examColumns)
+	var args []interface{}
+	if stage != "all" {
+		args = append(args, stage)
+		query += fmt.Sprintf(" AND sub.stage = $%d", len(args))
+	}
+	if semester != "" {
+		args = append(args, semester)
+		query += fmt.Sprintf(" AND e.semester = $%d", len(args))
+	}
+	query += " ORDER BY e.exam_date, e.start_time, sub.stage, sub.subject_name"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exams := []*Exam{}
+	for rows.Next() {
+		var exam Exam
+		err := scanExam(rows, &exam)
+		if err != nil {
+			return nil, err
+		}
+		exams = append(exams, &exam)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return exams, nil
+}
+
+func (m ExamModel) Delete(year string, id int64) error {
+	query := fmt.Sprintf(`DELETE FROM exams_%s WHERE id = $1`, year)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Conflicts returns every conflict in the timetable involving an exam of the stage.
+func (m ExamModel) Conflicts(year, stage string) ([]*ExamConflict, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return examConflicts(ctx, m.DB, year, stage, 0)
+}
+
+// examConflicts finds the pairs of exams on the same day whose times overlap and
+// reports them once per kind. Only pairs involving an exam of the stage ("" or "all"
+// for every stage) and, when examId isn't 0, that exam are checked. A student takes
+// the subjects of their stage and those they carry over, less the exempted ones.
+func examConflicts(ctx context.Context, db queryer, year, stage string, examId int64) ([]*ExamConflict, error) {
+	var args []interface{}
+	filter := ""
+	if stage != "" && stage != "all" {
+		args = append(args, stage)
+		filter += fmt.Sprintf(" AND (a.stage = $%[1]d OR b.stage = $%[1]d)", len(args))
+	}
+	if examId != 0 {
+		args = append(args, examId)
+		filter += fmt.Sprintf(" AND (a.id = $%[1]d OR b.id = $%[1]d)", len(args))
+	}
+	query := fmt.Sprintf(`
+	WITH e AS (
+		SELECT e.id, e.subject_id, sub.subject_name, sub.stage, e.exam_date, e.start_time, e.end_time, e.room
+		FROM exams_%[1]s e
+		JOIN subjects_%[1]s sub ON e.subject_id = sub.subject_id
+	),
+	pairs AS (
+		SELECT a.id AS a_id, a.subject_id AS a_subject, a.subject_name AS a_name, a.stage AS a_stage, a.room AS a_room,
+		b.id AS b_id, b.subject_id AS b_subject, b.subject_name AS b_name, b.stage AS b_stage, b.room AS b_room,
+		a.exam_date
+		FROM e a
+		JOIN e b ON a.id < b.id AND a.exam_date = b.exam_date
+		AND a.start_time < b.end_time AND b.start_time < a.end_time
+		WHERE TRUE%[2]s
+	),
+	takes AS (
+		SELECT s.student_id, sub.subject_id
+		FROM students_%[1]s s
+		JOIN subjects_%[1]s sub ON sub.stage = s.stage
+		UNION
+		SELECT student_id, subject_id FROM carryovers_%[1]s
+		EXCEPT
+		SELECT student_id, subject_id FROM exempted_%[1]s
+	)
+	SELECT '%[3]s', a_id, a_name, b_id, b_name, exam_date, a_stage, '', ARRAY[]::text[]
+	FROM pairs WHERE a_stage = b_stage
+	UNION ALL
+	SELECT '%[4]s', a_id, a_name, b_id, b_name, exam_date, '', a_room, ARRAY[]::text[]
+	FROM pairs WHERE lower(trim(a_room)) = lower(trim(b_room))
+	UNION ALL
+	SELECT '%[5]s', a_id, a_name, b_id, b_name, exam_date, '', '', array_agg(s.student_name::text ORDER BY s.student_name)
+	FROM pairs p
+	JOIN takes ta ON ta.subject_id = p.a_subject
+	JOIN takes tb ON tb.subject_id = p.b_subject AND tb.student_id = ta.student_id
+	JOIN students_%[1]s s ON s.student_id = ta.student_id
+	WHERE a_stage <> b_stage
+	GROUP BY a_id, a_name, b_id, b_name, exam_date
+	ORDER BY 6, 1, 2, 4`, year, filter, ExamConflictStage, ExamConflictRoom, ExamConflictCarryover)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conflicts := []*ExamConflict{}
+	for rows.Next() {
+		var conflict ExamConflict
+		var date time.Time
+		err := rows.Scan(
+			&conflict.Kind,
+			&conflict.ExamId,
+			&conflict.SubjectName,
+			&conflict.OtherExamId,
+			&conflict.OtherSubjectName,
+			&date,
+			&conflict.Stage,
+			&conflict.Room,
+			pq.Array(&conflict.Students),
+		)
+		if err != nil {
+			return nil, err
+		}
+		conflict.Date = date.Format(time.DateOnly)
+		conflicts = append(conflicts, &conflict)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
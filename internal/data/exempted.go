@@ -51,50 +51,64 @@ func (m ExemptedModel) Insert(year string, exempted *Exempted) error {
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&exempted.Id, &exempted.CreatedAt)
 }
 
-// ddd
-func (m ExemptedModel) GetAll(year, stage string) ([]*Exempted, error) {
+// GetAll lists the exempteds of the stage's students that match the filters, a page at
+// a time. Name matches the student or the subject name.
+func (m ExemptedModel) GetAll(year, stage string, filters Filters) ([]*Exempted, Metadata, error) {
 	if strings.TrimSpace(year) == "" {
-		return nil, errors.New("invalid year")
+		return nil, Metadata{}, errors.New("invalid year")
 	}
 	exemptedTable := fmt.Sprintf("exempted_%s", year)
 	studentsTable := fmt.Sprintf("students_%s", year)
 	subjectsTable := fmt.Sprintf("subjects_%s", year)
 	query := fmt.Sprintf(`
-		SELECT c.id, s.student_name AS student_name, sub.subject_name AS subject_name
+		SELECT count(*) OVER(), c.id, s.student_name AS student_name, sub.subject_name AS subject_name
 		FROM %s c
 		JOIN %s s ON c.student_id = s.student_id
 		JOIN %s sub ON c.subject_id = sub.subject_id
+		WHERE 1 = 1
 	`, exemptedTable, studentsTable, subjectsTable)
 	var args []interface{}
 	if stage != "all" {
-		query += " WHERE s.stage = $1"
 		args = append(args, stage)
+		query += fmt.Sprintf(" AND s.stage = $%d", len(args))
+	}
+	columns := filterColumns{
+		name:       []string{"s.student_name", "sub.subject_name"},
+		state:      "s.state",
+		department: "sub.department",
+		semester:   "sub.semester",
 	}
+	conditions, args := filters.conditions(columns, args)
+	query += conditions + filters.orderBy("id")
+	limit, args := filters.limitOffset(args)
+	query += limit
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	var exempteds []*Exempted
 	for rows.Next() {
 		var exempted Exempted
 		err := rows.Scan(
+			&totalRecords,
 			&exempted.Id,
 			&exempted.StudentName,
 			&exempted.SubjectName,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		exempteds = append(exempteds, &exempted)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return exempteds, nil
+	return exempteds, calculateMetadata(totalRecords, filters), nil
 }
 
 func (m ExemptedModel) Get(year string, id int64) (*Exempted, error) {
@@ -108,16 +122,18 @@ func (m ExemptedModel) Get(year string, id int64) (*Exempted, error) {
 	studentsTable := fmt.Sprintf("students_%s", year)
 	subjectsTable := fmt.Sprintf("subjects_%s", year)
 	query := fmt.Sprintf(`
-	SELECT c.id, s.name AS student_name, sub.name AS subject_name
+	SELECT c.id, c.student_id, c.subject_id, s.student_name AS student_name, sub.subject_name AS subject_name
 	FROM %s c
-	JOIN %s s ON c.student_id = s.id
-	JOIN %s sub ON c.subject.id = sub.id
+	JOIN %s s ON c.student_id = s.student_id
+	JOIN %s sub ON c.subject_id = sub.subject_id
 	WHERE c.id = $1;`, exemptedTable, studentsTable, subjectsTable)
 	var exempted Exempted
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&exempted.Id,
+		&exempted.StudentId,
+		&exempted.SubjectId,
 		&exempted.StudentName,
 		&exempted.SubjectName,
 	)
@@ -272,6 +288,23 @@ func (m ExemptedModel) Delete(year string, id int64) error {
 	return nil
 }
 
+// Exists reports whether the student is exempted from the subject in the given year.
+func (m ExemptedModel) Exists(year string, studentId, subjectId int64) (bool, error) {
+	if strings.TrimSpace(year) == "" {
+		return false, errors.New("invalid year")
+	}
+	exemptedTable := fmt.Sprintf("exempted_%s", year)
+	query := fmt.Sprintf(`
+	SELECT EXISTS (
+		SELECT 1 FROM %s WHERE student_id = $1 AND subject_id = $2
+	);`, exemptedTable)
+	var exists bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, studentId, subjectId).Scan(&exists)
+	return exists, err
+}
+
 func (m ExemptedModel) GetStage(id int64, year string) (string, error) {
 	studentsTable := fmt.Sprintf("students_%s", year)
 	exemptedTable := fmt.Sprintf("exempted_%s", year)
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"collegecm.hamid.net/internal/validator"
+)
+
+// Filters holds the paging, sorting and filtering options of a list endpoint. A zero
+// PageSize returns every matching row on a single page; the zero Filters therefore
+// lists everything in the default order.
+type Filters struct {
+	Page         int
+	PageSize     int
+	Sort         string
+	SortSafelist []string
+	// Name matches rows whose name contains it, State, Department and Semester match
+	// exactly. Lists that have no such column ignore them.
+	Name       string
+	State      string
+	Department string
+	Semester   string
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(f.Page > 0, "page", "يجب ان يكون اكبر من صفر")
+	v.Check(f.Page <= 10_000_000, "page", "يجب ان لا يزيد عن 10 مليون")
+	v.Check(f.PageSize >= 0, "page_size", "يجب ان يكون اكبر من صفر")
+	v.Check(f.PageSize <= 1000, "page_size", "يجب ان لا يزيد عن 1000")
+	if f.Sort != "" {
+		v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "قيمة غير صحيحة")
+	}
+}
+
+// sortColumn returns the column to sort by. The sort value must have been checked
+// against the safelist, as it ends up in the query text.
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// orderBy returns the ORDER BY clause, with tiebreak as the last key so pages don't
+// overlap. Sort columns are output column names of the list query.
+func (f Filters) orderBy(tiebreak string) string {
+	if f.Sort == "" {
+		return " ORDER BY " + tiebreak
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, %s", f.sortColumn(), f.sortDirection(), tiebreak)
+}
+
+// limitOffset returns the LIMIT and OFFSET clause for the page, adding its arguments
+// to args.
+func (f Filters) limitOffset(args []interface{}) (string, []interface{}) {
+	if f.PageSize == 0 {
+		return "", args
+	}
+	page := f.Page
+	if page < 1 {
+		page = 1
+	}
+	args = append(args, f.PageSize, (page-1)*f.PageSize)
+	return fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args
+}
+
+// filterColumns names the SQL expressions a list query matches Filters against. Empty
+// fields mean the list can't be filtered that way.
+type filterColumns struct {
+	name       []string
+	state      string
+	department string
+	semester   string
+}
+
+// conditions returns the " AND ..." conditions for the set filters, adding their
+// arguments to args.
+func (f Filters) conditions(columns filterColumns, args []interface{}) (string, []interface{}) {
+	var query string
+	if f.Name != "" && len(columns.name) > 0 {
+		args = append(args, "%"+escapeLike(f.Name)+"%")
+		var matches []string
+		for _, column := range columns.name {
+			matches = append(matches, fmt.Sprintf("%s ILIKE $%d", column, len(args)))
+		}
+		query += " AND (" + strings.Join(matches, " OR ") + ")"
+	}
+	for _, filter := range []struct{ value, column string }{
+		{f.State, columns.state},
+		{f.Department, columns.department},
+		{f.Semester, columns.semester},
+	} {
+		if filter.value != "" && filter.column != "" {
+			args = append(args, filter.value)
+			query += fmt.Sprintf(" AND %s = $%d", filter.column, len(args))
+		}
+	}
+	return query, args
+}
+
+// escapeLike escapes the LIKE wildcards in s so it's matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Metadata describes the page a list endpoint returned.
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+}
+
+// calculateMetadata works out the page metadata from the total number of matching
+// rows. It returns an empty Metadata when there are none.
+func calculateMetadata(totalRecords int, f Filters) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+	if f.PageSize == 0 {
+		return Metadata{CurrentPage: 1, PageSize: totalRecords, FirstPage: 1, LastPage: 1, TotalRecords: totalRecords}
+	}
+	return Metadata{
+		CurrentPage:  f.Page,
+		PageSize:     f.PageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(f.PageSize))),
+		TotalRecords: totalRecords,
+	}
+}
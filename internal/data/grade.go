@@ -0,0 +1,114 @@
+package data
+
+import "math"
+
+// The grade bands used by the college, from the highest to the lowest passing band.
+// A mark falls in the first band whose minimum percentage it reaches.
+const (
+	GradeExcellent  = "امتياز"
+	GradeVeryGood   = "جيد جداً"
+	GradeGood       = "جيد"
+	GradeAverage    = "متوسط"
+	GradeAcceptable = "مقبول"
+	GradeFail       = "راسب"
+)
+
+// DefaultPassThreshold is the pass mark used when none is configured.
+const DefaultPassThreshold = 50
+
+// How a second-round (الدور الثاني) final mark is combined with the first round:
+// SecondRoundBest keeps whichever total is higher, SecondRoundReplace always uses the
+// second round, and SecondRoundCapped keeps the higher total but never lets a
+// second-round result go above the pass mark.
+const (
+	SecondRoundBest    = "best"
+	SecondRoundReplace = "replace"
+	SecondRoundCapped  = "capped"
+)
+
+// SecondRoundPolicies lists the accepted values for GradePolicy.SecondRound.
+var SecondRoundPolicies = []string{SecondRoundBest, SecondRoundReplace, SecondRoundCapped}
+
+var gradeBands = []struct {
+	min  float64
+	name string
+}{
+	{90, GradeExcellent},
+	{80, GradeVeryGood},
+	{70, GradeGood},
+	{60, GradeAverage},
+	{50, GradeAcceptable},
+}
+
+// GradePolicy holds the configurable rules used to turn raw marks into results.
+type GradePolicy struct {
+	// PassThreshold is the percentage of the subject's full mark (max semester mark
+	// plus max final exam) a student needs to pass.
+	PassThreshold float64
+	// SecondRound is one of SecondRoundPolicies.
+	SecondRound string
+}
+
+// Grade fills in the derived Round, Total, Percentage, Passed and Grade fields of the
+// mark. The mark must carry the subject maxima (MaxSemesterMark and MaxFinalExam).
+func (p GradePolicy) Grade(mark *Mark) {
+	fullMark := mark.MaxSemesterMark + mark.MaxFinalExam
+	mark.Round = 1
+	mark.Total = mark.SemesterMark + mark.FinalMark
+	if mark.SecondFinalMark != nil {
+		second := mark.SemesterMark + *mark.SecondFinalMark
+		switch p.SecondRound {
+		case SecondRoundReplace:
+			mark.Round, mark.Total = 2, second
+		case SecondRoundCapped:
+			passMark := int(math.Ceil(p.PassThreshold * float64(fullMark) / 100))
+			if second > passMark {
+				second = passMark
+			}
+			if second > mark.Total {
+				mark.Round, mark.Total = 2, second
+			}
+		default:
+			if second > mark.Total {
+				mark.Round, mark.Total = 2, second
+			}
+		}
+	}
+	mark.Percentage = percentage(mark.Total, fullMark)
+	mark.Passed = mark.Percentage >= p.PassThreshold
+	mark.Grade = p.band(mark.Percentage, mark.Passed)
+}
+
+// PassedFirstRound reports whether the mark passes on its first-round final alone.
+func (p GradePolicy) PassedFirstRound(mark *Mark) bool {
+	total := mark.SemesterMark + mark.FinalMark
+	return percentage(total, mark.MaxSemesterMark+mark.MaxFinalExam) >= p.PassThreshold
+}
+
+// GradeAll grades every mark in the slice.
+func (p GradePolicy) GradeAll(marks []*Mark) {
+	for _, mark := range marks {
+		p.Grade(mark)
+	}
+}
+
+func (p GradePolicy) band(pct float64, passed bool) string {
+	if !passed {
+		return GradeFail
+	}
+	for _, band := range gradeBands {
+		if pct >= band.min {
+			return band.name
+		}
+	}
+	// the pass threshold is configured below the lowest band.
+	return GradeAcceptable
+}
+
+// percentage returns total as a percentage of fullMark, rounded to two decimal places.
+func percentage(total, fullMark int) float64 {
+	if fullMark <= 0 {
+		return 0
+	}
+	return math.Round(float64(total)*10000/float64(fullMark)) / 100
+}
@@ -0,0 +1,103 @@
+package data
+
+import "testing"
+
+func TestGradeBands(t *testing.T) {
+	policy := GradePolicy{PassThreshold: DefaultPassThreshold, SecondRound: SecondRoundBest}
+	tests := []struct {
+		name       string
+		total      int
+		fullMark   int
+		percentage float64
+		passed     bool
+		grade      string
+	}{
+		{"full mark", 100, 100, 100, true, GradeExcellent},
+		{"excellent", 90, 100, 90, true, GradeExcellent},
+		{"below excellent", 89, 100, 89, true, GradeVeryGood},
+		{"very good", 80, 100, 80, true, GradeVeryGood},
+		{"below very good", 79, 100, 79, true, GradeGood},
+		{"good", 70, 100, 70, true, GradeGood},
+		{"below good", 69, 100, 69, true, GradeAverage},
+		{"average", 60, 100, 60, true, GradeAverage},
+		{"below average", 59, 100, 59, true, GradeAcceptable},
+		{"pass mark", 50, 100, 50, true, GradeAcceptable},
+		{"below pass mark", 49, 100, 49, false, GradeFail},
+		{"zero", 0, 100, 0, false, GradeFail},
+		{"rounded percentage", 2, 3, 66.67, true, GradeAverage},
+		{"no full mark", 0, 0, 0, false, GradeFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mark := &Mark{
+				SemesterMark:    tt.total * 40 / 100,
+				MaxSemesterMark: tt.fullMark * 40 / 100,
+				MaxFinalExam:    tt.fullMark - tt.fullMark*40/100,
+			}
+			mark.FinalMark = tt.total - mark.SemesterMark
+			policy.Grade(mark)
+			if mark.Round != 1 || mark.Total != tt.total {
+				t.Errorf("round %d total %d, want round 1 total %d", mark.Round, mark.Total, tt.total)
+			}
+			if mark.Percentage != tt.percentage || mark.Passed != tt.passed || mark.Grade != tt.grade {
+				t.Errorf("got %v%% passed %v %q, want %v%% passed %v %q",
+					mark.Percentage, mark.Passed, mark.Grade, tt.percentage, tt.passed, tt.grade)
+			}
+		})
+	}
+}
+
+func TestGradeBelowLowestBand(t *testing.T) {
+	policy := GradePolicy{PassThreshold: 40, SecondRound: SecondRoundBest}
+	mark := &Mark{SemesterMark: 20, MaxSemesterMark: 40, FinalMark: 25, MaxFinalExam: 60}
+	policy.Grade(mark)
+	if !mark.Passed || mark.Grade != GradeAcceptable {
+		t.Errorf("got passed %v %q, want passed %q", mark.Passed, mark.Grade, GradeAcceptable)
+	}
+}
+
+func TestGradeSecondRound(t *testing.T) {
+	second := func(mark int) *int { return &mark }
+	tests := []struct {
+		name      string
+		policy    string
+		passed    int // pass threshold percentage
+		semester  int
+		final     int
+		second    *int
+		round     int
+		total     int
+		wantPass  bool
+		wantGrade string
+	}{
+		{"no second round", SecondRoundCapped, 50, 20, 20, nil, 1, 40, false, GradeFail},
+		{"best keeps the higher second", SecondRoundBest, 50, 20, 20, second(45), 2, 65, true, GradeAverage},
+		{"best keeps the higher first", SecondRoundBest, 50, 20, 25, second(10), 1, 45, false, GradeFail},
+		{"replace uses a lower second", SecondRoundReplace, 50, 20, 25, second(10), 2, 30, false, GradeFail},
+		{"replace uses a higher second", SecondRoundReplace, 50, 20, 20, second(45), 2, 65, true, GradeAverage},
+		{"capped at the pass mark", SecondRoundCapped, 50, 20, 20, second(45), 2, 50, true, GradeAcceptable},
+		{"capped just reaching the pass mark", SecondRoundCapped, 50, 20, 20, second(30), 2, 50, true, GradeAcceptable},
+		{"capped below the pass mark", SecondRoundCapped, 50, 20, 20, second(25), 2, 45, false, GradeFail},
+		{"capped keeps the higher first", SecondRoundCapped, 50, 20, 28, second(10), 1, 48, false, GradeFail},
+		{"capped at a configured pass mark", SecondRoundCapped, 55, 20, 20, second(45), 2, 55, true, GradeAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := GradePolicy{PassThreshold: float64(tt.passed), SecondRound: tt.policy}
+			mark := &Mark{
+				SemesterMark:    tt.semester,
+				MaxSemesterMark: 40,
+				FinalMark:       tt.final,
+				MaxFinalExam:    60,
+				SecondFinalMark: tt.second,
+			}
+			policy.Grade(mark)
+			if mark.Round != tt.round || mark.Total != tt.total {
+				t.Errorf("round %d total %d, want round %d total %d", mark.Round, mark.Total, tt.round, tt.total)
+			}
+			if mark.Passed != tt.wantPass || mark.Grade != tt.wantGrade {
+				t.Errorf("passed %v %q, want passed %v %q", mark.Passed, mark.Grade, tt.wantPass, tt.wantGrade)
+			}
+		})
+	}
+}
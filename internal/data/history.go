@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// StudentHistory is a student's path through the college, one entry per academic year
+// they were enrolled in.
+type StudentHistory struct {
+	StudentId   int64          `json:"student_id"`
+	StudentName string         `json:"student_name"`
+	Years       []*HistoryYear `json:"years"`
+}
+
+// HistoryYear holds what a user is allowed to see of one year: the parts they have no
+// read privilege on are left out.
+type HistoryYear struct {
+	Year       string               `json:"year"`
+	Stage      string               `json:"stage"`
+	State      string               `json:"state"`
+	Carryovers []*Carryover         `json:"carryovers,omitempty"`
+	Results    []*TranscriptSubject `json:"results,omitempty"`
+	Average    *float64             `json:"average,omitempty"`
+}
+
+type HistoryModel struct {
+	DB *sql.DB
+}
+
+// Enrolments finds the student in every year listed in the years table, oldest first.
+// Each returned Student has its Year set.
+func (m HistoryModel) Enrolments(studentId int64) ([]*Student, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return studentYears(ctx, m.DB, studentId)
+}
+
+// GetYear loads the student's carryovers and results of the student's year, as far as
+// access allows. The average is only given when none of the marks had to be left out.
+func (m HistoryModel) GetYear(student *Student, policy GradePolicy, access *CustomPrivilegeAccess) (*HistoryYear, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	year := &HistoryYear{Year: student.Year, Stage: student.Stage, State: student.State}
+	if access.Carryovers {
+		query := fmt.Sprintf(`
+		SELECT c.id, c.student_id, c.subject_id, s.subject_name
+		FROM carryovers_%s c
+		JOIN subjects_%s s ON c.subject_id = s.subject_id
+		WHERE c.student_id = $1
+		ORDER BY c.subject_id`, student.Year, student.Year)
+		rows, err := m.DB.QueryContext(ctx, query, student.StudentId)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		year.Carryovers = []*Carryover{}
+		for rows.Next() {
+			carryover := Carryover{StudentName: student.StudentName}
+			err := rows.Scan(&carryover.Id, &carryover.StudentId, &carryover.SubjectId, &carryover.SubjectName)
+			if err != nil {
+				return nil, err
+			}
+			year.Carryovers = append(year.Carryovers, &carryover)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if !access.Marks && !access.Exempted {
+		return year, nil
+	}
+	results, err := transcriptYear(ctx, m.DB, student, policy)
+	if err != nil {
+		return nil, err
+	}
+	complete := true
+	year.Results = []*TranscriptSubject{}
+	for _, result := range results.Subjects {
+		if result.Exempted {
+			if access.Exempted {
+				year.Results = append(year.Results, result)
+			}
+			continue
+		}
+		if !access.Marks || !access.MarkSubjects.Allows(result.SubjectId) {
+			complete = false
+			continue
+		}
+		year.Results = append(year.Results, result)
+	}
+	if complete {
+		year.Average = &results.Average
+	}
+	return year, nil
+}
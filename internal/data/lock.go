@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"collegecm.hamid.net/internal/validator"
+)
+
+// Lock freezes the marks, carryovers and exemptions of a stage once the exam committee
+// has signed off its results. SubjectId -1 locks every subject of the stage.
+type Lock struct {
+	ID         int64     `json:"id"`
+	Year       string    `json:"year"`
+	Stage      string    `json:"stage"`
+	SubjectId  int64     `json:"subject_id"`
+	LockedBy   int64     `json:"locked_by"`
+	LockedName string    `json:"locked_by_name"`
+	LockedAt   time.Time `json:"locked_at"`
+}
+
+func ValidateLock(v *validator.Validator, lock *Lock) {
+	ValidateYear(v, &Year{Year: lock.Year})
+	v.Check(validator.In(lock.Stage, Stages...), "المرحلة", "يجب تزويد المعلومات")
+	v.Check(lock.SubjectId == -1 || lock.SubjectId > 0, "المادة", "يجب تزويد المعلومات")
+}
+
+type LockModel struct {
+	DB *sql.DB
+}
+
+// Insert locks the stage or subject. Locking something that is already locked keeps
+// the original lock, whose details are loaded into lock. It reports whether a new lock
+// was created.
+func (m LockModel) Insert(lock *Lock) (bool, error) {
+	query := `
+	WITH inserted AS (
+		INSERT INTO locks (year, stage, subject_id, locked_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (year, stage, subject_id) DO NOTHING
+		RETURNING id, locked_by, locked_at
+	)
+	SELECT id, locked_by, locked_at, TRUE FROM inserted
+	UNION ALL
+	SELECT id, locked_by, locked_at, FALSE FROM locks
+	WHERE year = $1 AND stage = $2 AND subject_id = $3
+	LIMIT 1`
+	args := []interface{}{lock.Year, lock.Stage, lock.SubjectId, lock.LockedBy}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var created bool
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&lock.ID, &lock.LockedBy, &lock.LockedAt, &created)
+	return created, err
+}
+
+// Delete removes the lock and returns it as it was.
+func (m LockModel) Delete(year, stage string, subjectId int64) (*Lock, error) {
+	query := `
+	DELETE FROM locks
+	WHERE year = $1 AND stage = $2 AND subject_id = $3
+	RETURNING id, year, stage, subject_id, locked_by, locked_at`
+	var lock Lock
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, year, stage, subjectId).Scan(
+		&lock.ID,
+		&lock.Year,
+		&lock.Stage,
+		&lock.SubjectId,
+		&lock.LockedBy,
+		&lock.LockedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &lock, nil
+}
+
+func (m LockModel) GetAll(year string) ([]*Lock, error) {
+	query := `
+	SELECT l.id, l.year, l.stage, l.subject_id, l.locked_by, COALESCE(u.username, ''), l.locked_at
+	FROM locks l
+	LEFT JOIN users u ON l.locked_by = u.id
+	WHERE l.year = $1
+	ORDER BY l.stage, l.subject_id`
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := []*Lock{}
+	for rows.Next() {
+		var lock Lock
+		err := rows.Scan(
+			&lock.ID,
+			&lock.Year,
+			&lock.Stage,
+			&lock.SubjectId,
+			&lock.LockedBy,
+			&lock.LockedName,
+			&lock.LockedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, &lock)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return locks, nil
+}
+
+// IsSubjectLocked reports whether the subject, or the whole stage it belongs to, is
+// locked in the year.
+func (m LockModel) IsSubjectLocked(year string, subjectId int64) (bool, error) {
+	if strings.TrimSpace(year) == "" {
+		return false, errors.New("invalid year")
+	}
+	subjectsTable := fmt.Sprintf("subjects_%s", year)
+	query := fmt.Sprintf(`
+	SELECT EXISTS (
+		SELECT 1
+		FROM locks l
+		JOIN %s sub ON sub.subject_id = $2
+		WHERE l.year = $1 AND l.stage = sub.stage AND l.subject_id IN (-1, sub.subject_id)
+	)`, subjectsTable)
+	var locked bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, year, subjectId).Scan(&locked)
+	return locked, err
+}
+
+// IsStudentLocked reports whether deleting the student would change locked results: a
+// lock on their stage, or on the stage or subject of any of their marks, carryovers or
+// exemptions, all of which go with the student.
+func (m LockModel) IsStudentLocked(year string, studentId int64) (bool, error) {
+	if strings.TrimSpace(year) == "" {
+		return false, errors.New("invalid year")
+	}
+	query := fmt.Sprintf(`
+	SELECT EXISTS (
+		SELECT 1
+		FROM locks l
+		JOIN students_%[1]s s ON s.student_id = $2
+		WHERE l.year = $1 AND l.stage = s.stage AND l.subject_id = -1
+		UNION ALL
+		SELECT 1
+		FROM locks l
+		JOIN subjects_%[1]s sub ON l.stage = sub.stage AND l.subject_id IN (-1, sub.subject_id)
+		WHERE l.year = $1 AND sub.subject_id IN (
+			SELECT subject_id FROM marks_%[1]s WHERE student_id = $2
+			UNION
+			SELECT subject_id FROM carryovers_%[1]s WHERE student_id = $2
+			UNION
+			SELECT subject_id FROM exempted_%[1]s WHERE student_id = $2
+		)
+	)`, year)
+	var locked bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, year, studentId).Scan(&locked)
+	return locked, err
+}
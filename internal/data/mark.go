@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"collegecm.hamid.net/internal/validator"
+	"github.com/lib/pq"
 )
 
 type Mark struct {
@@ -21,6 +22,12 @@ type Mark struct {
 	MaxSemesterMark int       `json:"max_semester_mark"`
 	FinalMark       int       `json:"final_mark"`
 	MaxFinalExam    int       `json:"max_final_exam"`
+	SecondFinalMark *int      `json:"second_final_mark"`
+	Round           int       `json:"round"`
+	Total           int       `json:"total"`
+	Percentage      float64   `json:"percentage"`
+	Passed          bool      `json:"passed"`
+	Grade           string    `json:"grade"`
 	CreatedAt       time.Time `json:"-"`
 }
 
@@ -32,6 +39,10 @@ func ValidateMark(v *validator.Validator, mark *Mark, sem, fin int) {
 	v.Check(mark.FinalMark >= 0, "درجة الامتحان النهائي", "يجب ان يكون 0 او اكبر")
 	v.Check(mark.SemesterMark <= sem, "السعي", "يجب ان يساوي او اقل من درجة السعي القصوى")
 	v.Check(mark.FinalMark <= fin, "درجة الامتحان النهائي", "يجب ان يساوي او اقل من درجة الامتحان القصوى")
+	if mark.SecondFinalMark != nil {
+		v.Check(*mark.SecondFinalMark >= 0, "درجة الدور الثاني", "يجب ان يكون 0 او اكبر")
+		v.Check(*mark.SecondFinalMark <= fin, "درجة الدور الثاني", "يجب ان يساوي او اقل من درجة الامتحان القصوى")
+	}
 }
 
 type MarkModel struct {
@@ -63,31 +74,124 @@ func (m MarkModel) Insert(year string, mark *Mark) error {
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&mark.Id, &mark.CreatedAt)
 }
 
-func (m MarkModel) GetAll(year, stage string) ([]*Mark, error) {
+// GetAll lists the marks of the stage's students that match the filters, a page at a
+// time, limited to the subjects in access. Name matches the student or the subject
+// name.
+func (m MarkModel) GetAll(year, stage string, access *SubjectAccess, filters Filters) ([]*Mark, Metadata, error) {
 	if strings.TrimSpace(year) == "" {
-		return nil, errors.New("invalid year")
+		return nil, Metadata{}, errors.New("invalid year")
 	}
 	marksTable := fmt.Sprintf("marks_%s", year)
 	studentsTable := fmt.Sprintf("students_%s", year)
 	subjectsTable := fmt.Sprintf("subjects_%s", year)
 	query := fmt.Sprintf(`
 	SELECT
+	count(*) OVER(),
 	c.id,
 	s.student_name AS student_name,
 	sub.subject_name AS subject_name,
 	c.semester_mark,
 	sub.max_semester_mark AS max_semester_mark,
 	c.final_mark,
-	sub.max_final_exam AS max_final_exam
+	sub.max_final_exam AS max_final_exam,
+	c.second_final_mark
 	FROM %s c
 	JOIN %s s ON c.student_id = s.student_id
 	JOIN %s sub ON c.subject_id = sub.subject_id
+	WHERE 1 = 1
 	`, marksTable, studentsTable, subjectsTable)
 	var args []interface{}
 	if stage != "all" {
-		query += " WHERE s.stage = $1"
 		args = append(args, stage)
+		query += fmt.Sprintf(" AND s.stage = $%d", len(args))
+	}
+	if access != nil && !access.All {
+		args = append(args, pq.Array(access.SubjectIds))
+		query += fmt.Sprintf(" AND c.subject_id = ANY($%d)", len(args))
 	}
+	columns := filterColumns{
+		name:       []string{"s.student_name", "sub.subject_name"},
+		state:      "s.state",
+		department: "sub.department",
+		semester:   "sub.semester",
+	}
+	conditions, args := filters.conditions(columns, args)
+	query += conditions + filters.orderBy("id")
+	limit, args := filters.limitOffset(args)
+	query += limit
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	var marks []*Mark
+	for rows.Next() {
+		var mark Mark
+		err := rows.Scan(
+			&totalRecords,
+			&mark.Id,
+			&mark.StudentName,
+			&mark.SubjectName,
+			&mark.SemesterMark,
+			&mark.MaxSemesterMark,
+			&mark.FinalMark,
+			&mark.MaxFinalExam,
+			&mark.SecondFinalMark,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		marks = append(marks, &mark)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return marks, calculateMetadata(totalRecords, filters), nil
+}
+
+// GetSecondRound returns the marks of a stage that are not exempted, together with
+// the student and subject ids, so the caller can decide which of them are eligible
+// for the second round. Like GetAll, it's limited to the subjects covered by access.
+func (m MarkModel) GetSecondRound(year, stage string, access *SubjectAccess) ([]*Mark, error) {
+	if strings.TrimSpace(year) == "" {
+		return nil, errors.New("invalid year")
+	}
+	marksTable := fmt.Sprintf("marks_%s", year)
+	studentsTable := fmt.Sprintf("students_%s", year)
+	subjectsTable := fmt.Sprintf("subjects_%s", year)
+	exemptedTable := fmt.Sprintf("exempted_%s", year)
+	query := fmt.Sprintf(`
+	SELECT
+	c.id,
+	c.student_id,
+	c.subject_id,
+	s.student_name AS student_name,
+	sub.subject_name AS subject_name,
+	c.semester_mark,
+	sub.max_semester_mark AS max_semester_mark,
+	c.final_mark,
+	sub.max_final_exam AS max_final_exam,
+	c.second_final_mark
+	FROM %s c
+	JOIN %s s ON c.student_id = s.student_id
+	JOIN %s sub ON c.subject_id = sub.subject_id
+	LEFT JOIN %s e ON e.student_id = c.student_id AND e.subject_id = c.subject_id
+	WHERE e.id IS NULL
+	`, marksTable, studentsTable, subjectsTable, exemptedTable)
+	var args []interface{}
+	if stage != "all" {
+		args = append(args, stage)
+		query += fmt.Sprintf(" AND s.stage = $%d", len(args))
+	}
+	if access != nil && !access.All {
+		args = append(args, pq.Array(access.SubjectIds))
+		query += fmt.Sprintf(" AND c.subject_id = ANY($%d)", len(args))
+	}
+	query += " ORDER BY s.student_name, sub.subject_name"
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, args...)
@@ -101,12 +205,15 @@ func (m MarkModel) GetAll(year, stage string) ([]*Mark, error) {
 		var mark Mark
 		err := rows.Scan(
 			&mark.Id,
+			&mark.StudentId,
+			&mark.SubjectId,
 			&mark.StudentName,
 			&mark.SubjectName,
 			&mark.SemesterMark,
 			&mark.MaxSemesterMark,
 			&mark.FinalMark,
 			&mark.MaxFinalExam,
+			&mark.SecondFinalMark,
 		)
 		if err != nil {
 			return nil, err
@@ -134,7 +241,8 @@ func (m MarkModel) Get(year string, id int64) (*Mark, error) {
 	c.semester_mark,
 	sub.max_semester_mark AS max_semester_mark,
 	c.final_mark,
-	sub.max_final_exam AS max_final_exam
+	sub.max_final_exam AS max_final_exam,
+	c.second_final_mark
 	FROM %s c
 	JOIN %s s ON c.student_id = s.student_id
 	JOIN %s sub ON c.subject_id = sub.subject_id
@@ -150,6 +258,7 @@ func (m MarkModel) Get(year string, id int64) (*Mark, error) {
 		&mark.MaxSemesterMark,
 		&mark.FinalMark,
 		&mark.MaxFinalExam,
+		&mark.SecondFinalMark,
 	)
 	if err != nil {
 		switch {
@@ -167,7 +276,7 @@ func (m MarkModel) GetRaw(year string, id int64) (*Mark, error) {
 		return nil, errors.New("invalid year")
 	}
 	marksTable := fmt.Sprintf("marks_%s", year)
-	query := fmt.Sprintf(`SELECT id, student_id, subject_id, semester_mark, final_mark from %s WHERE id = $1;`, marksTable)
+	query := fmt.Sprintf(`SELECT id, student_id, subject_id, semester_mark, final_mark, second_final_mark from %s WHERE id = $1;`, marksTable)
 	var mark Mark
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -177,6 +286,7 @@ func (m MarkModel) GetRaw(year string, id int64) (*Mark, error) {
 		&mark.SubjectId,
 		&mark.SemesterMark,
 		&mark.FinalMark,
+		&mark.SecondFinalMark,
 	)
 	if err != nil {
 		switch {
@@ -196,7 +306,7 @@ func (m MarkModel) Update(year string, mark *Mark) error {
 	marksTable := fmt.Sprintf("marks_%s", year)
 	query := fmt.Sprintf(`
 	UPDATE %s
-	SET student_id = $2, subject_id = $3, semester_mark = $4, final_mark = $5
+	SET student_id = $2, subject_id = $3, semester_mark = $4, final_mark = $5, second_final_mark = $6
 	WHERE id = $1`, marksTable)
 	args := []interface{}{
 		&mark.Id,
@@ -204,6 +314,7 @@ func (m MarkModel) Update(year string, mark *Mark) error {
 		&mark.SubjectId,
 		&mark.SemesterMark,
 		&mark.FinalMark,
+		mark.SecondFinalMark,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -211,6 +322,85 @@ func (m MarkModel) Update(year string, mark *Mark) error {
 	return err
 }
 
+// MarkChange is the outcome of writing one mark with UpsertMany. Before holds the
+// previous marks and is nil when the mark was created.
+type MarkChange struct {
+	Before *Mark
+	After  *Mark
+}
+
+// UpsertMany inserts each mark, or overwrites the semester and final marks of the
+// existing mark of the same student and subject, all in one transaction along with
+// their audit entries by userId. The ids of the marks are filled in.
+func (m MarkModel) UpsertMany(year string, marks []*Mark, userId int64) ([]*MarkChange, error) {
+	if strings.TrimSpace(year) == "" {
+		return nil, errors.New("invalid year")
+	}
+	marksTable := fmt.Sprintf("marks_%s", year)
+	selectQuery := fmt.Sprintf(`
+	SELECT id, student_id, subject_id, semester_mark, final_mark, second_final_mark
+	FROM %s
+	WHERE student_id = $1 AND subject_id = $2
+	FOR UPDATE`, marksTable)
+	upsertQuery := fmt.Sprintf(`
+	INSERT INTO %s (student_id, subject_id, semester_mark, final_mark)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (student_id, subject_id) DO UPDATE
+	SET semester_mark = EXCLUDED.semester_mark, final_mark = EXCLUDED.final_mark
+	RETURNING id, created_at, second_final_mark`, marksTable)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	changes := make([]*MarkChange, 0, len(marks))
+	for _, mark := range marks {
+		change := &MarkChange{After: mark}
+		var before Mark
+		err := tx.QueryRowContext(ctx, selectQuery, mark.StudentId, mark.SubjectId).Scan(
+			&before.Id,
+			&before.StudentId,
+			&before.SubjectId,
+			&before.SemesterMark,
+			&before.FinalMark,
+			&before.SecondFinalMark,
+		)
+		switch {
+		case err == nil:
+			change.Before = &before
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, err
+		}
+		args := []interface{}{mark.StudentId, mark.SubjectId, mark.SemesterMark, mark.FinalMark}
+		err = tx.QueryRowContext(ctx, upsertQuery, args...).Scan(&mark.Id, &mark.CreatedAt, &mark.SecondFinalMark)
+		if err != nil {
+			return nil, err
+		}
+		var entry *AuditEntry
+		if change.Before == nil {
+			entry, err = NewAuditEntry(userId, year, "marks", mark.Id, AuditCreate, nil, mark)
+		} else {
+			entry, err = NewAuditEntry(userId, year, "marks", mark.Id, AuditUpdate, change.Before, mark)
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = insertAudit(ctx, tx, entry)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
 func (m MarkModel) Delete(year string, id int64) error {
 	if id < 0 {
 		return ErrRecordNotFound
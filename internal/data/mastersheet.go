@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MasterSheet is the official results sheet (ماستر شيت) of one stage: a row per student
+// with a cell per subject, plus the student's average and end-of-year decision.
+type MasterSheet struct {
+	Year     string            `json:"year"`
+	Stage    string            `json:"stage"`
+	Subjects []*Subject        `json:"subjects"`
+	Rows     []*MasterSheetRow `json:"rows"`
+}
+
+type MasterSheetRow struct {
+	StudentId   int64   `json:"student_id"`
+	StudentName string  `json:"student_name"`
+	State       string  `json:"state"`
+	Average     float64 `json:"average"`
+	Failed      int     `json:"failed"`
+	Decision    string  `json:"decision"`
+	// Cells is keyed by subject id; subjects the student has nothing recorded for are
+	// missing.
+	Cells map[int64]*MasterSheetCell `json:"cells"`
+}
+
+// MasterSheetCell is a student's result in one subject. Mark is nil when the subject
+// is exempted or no mark has been entered yet.
+type MasterSheetCell struct {
+	Mark      *Mark `json:"mark"`
+	Exempted  bool  `json:"exempted"`
+	Carryover bool  `json:"carryover"`
+}
+
+type MasterSheetModel struct {
+	DB *sql.DB
+}
+
+// Get builds the master sheet of the stage. The subject columns are the stage's active
+// subjects plus any other subject a student of the stage has a mark or a carryover in,
+// ordered by stage then subject id. Averages are worked out like AverageModel does and
+// decisions like the promotion does, so the sheet agrees with both.
+func (m MasterSheetModel) Get(year, stage string, policy GradePolicy, rules PromotionRules) (*MasterSheet, error) {
+	if strings.TrimSpace(year) == "" {
+		return nil, errors.New("invalid year")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	records, err := loadYearRecords(ctx, tx, year)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := make(map[int64]*Subject, len(records.subjects))
+	for _, subject := range records.subjects {
+		subjects[int64(subject.ID)] = subject
+	}
+	for _, mark := range records.marks {
+		if subject, ok := subjects[mark.SubjectId]; ok {
+			mark.MaxSemesterMark = subject.MaxSemesterMark
+			mark.MaxFinalExam = subject.MaxFinalExam
+			mark.SubjectName = subject.SubjectName
+		}
+	}
+
+	sheet := &MasterSheet{Year: year, Stage: stage, Subjects: []*Subject{}, Rows: []*MasterSheetRow{}}
+	columns := make(map[int64]bool)
+	for _, subject := range records.subjects {
+		if subject.Stage == stage && subject.Active != "لا" {
+			columns[int64(subject.ID)] = true
+		}
+	}
+	for _, student := range records.students {
+		if student.Stage != stage {
+			continue
+		}
+		row := &MasterSheetRow{
+			StudentId:   int64(student.StudentId),
+			StudentName: student.StudentName,
+			State:       student.State,
+			Cells:       make(map[int64]*MasterSheetCell),
+		}
+		average := &StudentAverage{Subjects: []*SubjectResult{}}
+		for _, subject := range records.subjects {
+			id := int64(subject.ID)
+			key := [2]int64{row.StudentId, id}
+			cell := &MasterSheetCell{
+				Exempted:  records.exempted[key],
+				Carryover: records.carryovers[key],
+			}
+			if mark, ok := records.marks[key]; ok && !cell.Exempted {
+				policy.Grade(mark)
+				cell.Mark = mark
+				average.Subjects = append(average.Subjects, &SubjectResult{
+					Credits:    subject.Credits,
+					Percentage: mark.Percentage,
+					Passed:     mark.Passed,
+				})
+			}
+			if cell.Mark == nil && !cell.Exempted && !cell.Carryover {
+				continue
+			}
+			if cell.Mark != nil || cell.Carryover {
+				columns[id] = true
+			}
+			row.Cells[id] = cell
+		}
+		average.compute()
+		row.Average = average.Average
+		row.Failed = average.Failed
+		row.Decision = evaluateStudent(student, records, policy, rules).Decision
+		sheet.Rows = append(sheet.Rows, row)
+	}
+
+	for _, subject := range records.subjects {
+		if columns[int64(subject.ID)] {
+			sheet.Subjects = append(sheet.Subjects, subject)
+		}
+	}
+	sort.SliceStable(sheet.Subjects, func(i, j int) bool {
+		return StageNumber(sheet.Subjects[i].Stage) < StageNumber(sheet.Subjects[j].Stage)
+	})
+	sort.SliceStable(sheet.Rows, func(i, j int) bool {
+		a, b := sheet.Rows[i], sheet.Rows[j]
+		if a.StudentName != b.StudentName {
+			return a.StudentName < b.StudentName
+		}
+		return a.StudentId < b.StudentId
+	})
+	return sheet, nil
+}
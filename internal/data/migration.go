@@ -0,0 +1,418 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"collegecm.hamid.net/migrations"
+)
+
+// Migrations recorded under GlobalScope belong to the shared tables, every other scope
+// value in schema_migrations is an academic year.
+const GlobalScope = "global"
+
+type Migration struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	up      string
+	down    string
+}
+
+type MigrationStatus struct {
+	Scope     string     `json:"scope"`
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+type MigrationModel struct {
+	DB *sql.DB
+}
+
+// Up applies every pending global migration, then every pending year migration to
+// each year in the years table. It returns a status row for each migration applied.
+func (m MigrationModel) Up() ([]*MigrationStatus, error) {
+	err := ensureMigrationsTable(m.DB)
+	if err != nil {
+		return nil, err
+	}
+	global, err := loadMigrations(".")
+	if err != nil {
+		return nil, err
+	}
+	applied, err := migrateUp(m.DB, GlobalScope, global, nil)
+	if err != nil {
+		return applied, err
+	}
+	years, err := YearModel{DB: m.DB}.GetAll()
+	if err != nil {
+		return applied, err
+	}
+	for _, year := range years {
+		yearApplied, err := applyYearMigrations(m.DB, year.Year)
+		applied = append(applied, yearApplied...)
+		if err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
+
+// Down reverts the latest steps migrations of the given scope. For the year scope the
+// same migrations are reverted on every year, so all years stay on one version.
+func (m MigrationModel) Down(scope string, steps int) ([]*MigrationStatus, error) {
+	err := ensureMigrationsTable(m.DB)
+	if err != nil {
+		return nil, err
+	}
+	if scope == GlobalScope {
+		global, err := loadMigrations(".")
+		if err != nil {
+			return nil, err
+		}
+		return migrateDown(m.DB, GlobalScope, global, steps, nil)
+	}
+	yearly, err := loadMigrations("year")
+	if err != nil {
+		return nil, err
+	}
+	years, err := YearModel{DB: m.DB}.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []*MigrationStatus
+	for _, year := range years {
+		yearReverted, err := migrateDown(m.DB, year.Year, yearly, steps, yearData(year.Year))
+		reverted = append(reverted, yearReverted...)
+		if err != nil {
+			return reverted, err
+		}
+	}
+	return reverted, nil
+}
+
+// Status lists every known migration for the global scope and each year, along with
+// the time it was applied (nil when pending).
+func (m MigrationModel) Status() ([]*MigrationStatus, error) {
+	err := ensureMigrationsTable(m.DB)
+	if err != nil {
+		return nil, err
+	}
+	global, err := loadMigrations(".")
+	if err != nil {
+		return nil, err
+	}
+	yearly, err := loadMigrations("year")
+	if err != nil {
+		return nil, err
+	}
+	scopes := map[string][]*Migration{GlobalScope: global}
+	order := []string{GlobalScope}
+	years, err := YearModel{DB: m.DB}.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, year := range years {
+		scopes[year.Year] = yearly
+		order = append(order, year.Year)
+	}
+	var statuses []*MigrationStatus
+	for _, scope := range order {
+		applied, err := appliedMigrations(m.DB, scope)
+		if err != nil {
+			return nil, err
+		}
+		for _, migration := range scopes[scope] {
+			status := &MigrationStatus{Scope: scope, Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// applyYearMigrations provisions (or upgrades) the per-year tables of a single year.
+func applyYearMigrations(db *sql.DB, year string) ([]*MigrationStatus, error) {
+	err := ensureMigrationsTable(db)
+	if err != nil {
+		return nil, err
+	}
+	yearly, err := loadMigrations("year")
+	if err != nil {
+		return nil, err
+	}
+	return migrateUp(db, year, yearly, yearData(year))
+}
+
+// provisionYear applies the year migrations for the year as part of tx. With all set
+// every migration is run again, including recorded ones, to recreate tables that went
+// missing; the up files are written to be safe to re-run.
+func provisionYear(ctx context.Context, tx *sql.Tx, year string, all bool) error {
+	yearly, err := loadMigrations("year")
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrationsTx(ctx, tx, year)
+	if err != nil {
+		return err
+	}
+	for _, migration := range yearly {
+		if _, ok := applied[migration.Version]; ok && !all {
+			continue
+		}
+		err = execMigration(ctx, tx, year, migration, true, yearData(year))
+		if err != nil {
+			return fmt.Errorf("migration %s %06d_%s: %w", year, migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// teardownYear runs every year down migration for the year, newest first, as part of
+// tx. It doesn't consult schema_migrations so that years created before migrations
+// were tracked are torn down as well; the down files are written to be safe to re-run.
+func teardownYear(ctx context.Context, tx *sql.Tx, year string) error {
+	yearly, err := loadMigrations("year")
+	if err != nil {
+		return err
+	}
+	for i := len(yearly) - 1; i >= 0; i-- {
+		err = execMigration(ctx, tx, year, yearly[i], false, yearData(year))
+		if err != nil {
+			return fmt.Errorf("migration %s %06d_%s: %w", year, yearly[i].Version, yearly[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func yearData(year string) interface{} {
+	return struct{ Year string }{Year: year}
+}
+
+func migrateUp(db *sql.DB, scope string, all []*Migration, tmplData interface{}) ([]*MigrationStatus, error) {
+	applied, err := appliedMigrations(db, scope)
+	if err != nil {
+		return nil, err
+	}
+	var done []*MigrationStatus
+	for _, migration := range all {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err = runMigration(db, scope, migration, true, tmplData)
+		if err != nil {
+			return done, fmt.Errorf("migration %s %06d_%s: %w", scope, migration.Version, migration.Name, err)
+		}
+		now := time.Now()
+		done = append(done, &MigrationStatus{Scope: scope, Version: migration.Version, Name: migration.Name, AppliedAt: &now})
+	}
+	return done, nil
+}
+
+func migrateDown(db *sql.DB, scope string, all []*Migration, steps int, tmplData interface{}) ([]*MigrationStatus, error) {
+	applied, err := appliedMigrations(db, scope)
+	if err != nil {
+		return nil, err
+	}
+	var done []*MigrationStatus
+	for i := len(all) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := all[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err = runMigration(db, scope, migration, false, tmplData)
+		if err != nil {
+			return done, fmt.Errorf("migration %s %06d_%s: %w", scope, migration.Version, migration.Name, err)
+		}
+		done = append(done, &MigrationStatus{Scope: scope, Version: migration.Version, Name: migration.Name})
+	}
+	return done, nil
+}
+
+// runMigration executes a single migration and records it in schema_migrations within
+// one transaction, so a failing file leaves neither its changes nor a record behind.
+func runMigration(db *sql.DB, scope string, migration *Migration, up bool, tmplData interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = execMigration(ctx, tx, scope, migration, up, tmplData)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execMigration runs a single migration and records it in schema_migrations as part of
+// the caller's transaction. Recording a migration that is already recorded is a no-op.
+func execMigration(ctx context.Context, tx *sql.Tx, scope string, migration *Migration, up bool, tmplData interface{}) error {
+	query := migration.down
+	if up {
+		query = migration.up
+	}
+	if tmplData != nil {
+		rendered, err := renderMigration(query, tmplData)
+		if err != nil {
+			return err
+		}
+		query = rendered
+	}
+	if strings.TrimSpace(query) != "" {
+		_, err := tx.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	if up {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO schema_migrations (scope, version, name)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (scope, version) DO NOTHING`, scope, migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+		DELETE FROM schema_migrations WHERE scope = $1 AND version = $2`, scope, migration.Version)
+	}
+	return err
+}
+
+func renderMigration(query string, tmplData interface{}) (string, error) {
+	tmpl, err := template.New("migration").Option("missingkey=error").Parse(query)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = tmpl.Execute(&b, tmplData)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+	scope VARCHAR(20) NOT NULL,
+	version BIGINT NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (scope, version)
+	);`
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.ExecContext(ctx, query)
+	return err
+}
+
+func appliedMigrations(db *sql.DB, scope string) (map[int64]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return appliedMigrationsTx(ctx, db, scope)
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func appliedMigrationsTx(ctx context.Context, db queryer, scope string) (map[int64]time.Time, error) {
+	query := `SELECT version, applied_at FROM schema_migrations WHERE scope = $1`
+	rows, err := db.QueryContext(ctx, query, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// loadMigrations reads the NNNNNN_name.up.sql / NNNNNN_name.down.sql pairs in dir of
+// the embedded migrations and returns them sorted by version.
+func loadMigrations(dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(migrations.FS, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		filename := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(filename, ".sql") {
+			continue
+		}
+		var up bool
+		var base string
+		switch {
+		case strings.HasSuffix(filename, ".up.sql"):
+			up = true
+			base = strings.TrimSuffix(filename, ".up.sql")
+		case strings.HasSuffix(filename, ".down.sql"):
+			base = strings.TrimSuffix(filename, ".down.sql")
+		default:
+			return nil, fmt.Errorf("migration %s: must end in .up.sql or .down.sql", filename)
+		}
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: missing version prefix", filename)
+		}
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version prefix", filename)
+		}
+		content, err := fs.ReadFile(migrations.FS, path.Join(dir, filename))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if up {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+	var all []*Migration
+	for _, migration := range byVersion {
+		all = append(all, migration)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Version < all[j].Version
+	})
+	return all, nil
+}
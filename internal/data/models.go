@@ -9,36 +9,67 @@ import (
 // looking up a movie that doesn't exist in our database.
 var (
 	ErrRecordNotFound = errors.New("record not found")
+	ErrDuplicateRole  = errors.New("duplicate role")
 )
 
 // Create a Models struct which wraps the MovieModel. We'll add other models to this,
 // like a UserModel and PermissionModel, as our build progresses.
 type Models struct {
-	Subjects   SubjectModel
-	Students   StudentModel
-	Carryovers CarryoverModel
-	Exempteds  ExemptedModel
-	Marks      MarkModel
-	Customs    CustomModel
-	Years      YearModel
-	Users      UserModel
-	Privileges PrivilegeModel
-	Tables     TableModel
+	Subjects     SubjectModel
+	Students     StudentModel
+	Carryovers   CarryoverModel
+	Exempteds    ExemptedModel
+	Marks        MarkModel
+	Customs      CustomModel
+	Years        YearModel
+	Users        UserModel
+	Privileges   PrivilegeModel
+	Tables       TableModel
+	Migrations   MigrationModel
+	Averages     AverageModel
+	Promotions   PromotionModel
+	Audit        AuditModel
+	Locks        LockModel
+	Roles        RoleModel
+	MasterSheets MasterSheetModel
+	Transcripts  TranscriptModel
+	Histories    HistoryModel
+	Search       SearchModel
+	Consistency  ConsistencyModel
+	Archives     ArchiveModel
+	Backups      BackupModel
+	Attendance   AttendanceModel
+	Exams        ExamModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
 // the initialized MovieModel.
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Subjects:   SubjectModel{DB: db},
-		Students:   StudentModel{DB: db},
-		Carryovers: CarryoverModel{DB: db},
-		Exempteds:  ExemptedModel{DB: db},
-		Marks:      MarkModel{DB: db},
-		Customs:    CustomModel{DB: db},
-		Years:      YearModel{DB: db},
-		Users:      UserModel{DB: db},
-		Privileges: PrivilegeModel{DB: db},
-		Tables:     TableModel{DB: db},
+		Subjects:     SubjectModel{DB: db},
+		Students:     StudentModel{DB: db},
+		Carryovers:   CarryoverModel{DB: db},
+		Exempteds:    ExemptedModel{DB: db},
+		Marks:        MarkModel{DB: db},
+		Customs:      CustomModel{DB: db},
+		Years:        YearModel{DB: db},
+		Users:        UserModel{DB: db},
+		Privileges:   PrivilegeModel{DB: db},
+		Tables:       TableModel{DB: db},
+		Migrations:   MigrationModel{DB: db},
+		Averages:     AverageModel{DB: db},
+		Promotions:   PromotionModel{DB: db},
+		Audit:        AuditModel{DB: db},
+		Locks:        LockModel{DB: db},
+		Roles:        RoleModel{DB: db},
+		MasterSheets: MasterSheetModel{DB: db},
+		Transcripts:  TranscriptModel{DB: db},
+		Histories:    HistoryModel{DB: db},
+		Search:       SearchModel{DB: db},
+		Consistency:  ConsistencyModel{DB: db},
+		Archives:     ArchiveModel{DB: db},
+		Backups:      BackupModel{DB: db},
+		Attendance:   AttendanceModel{DB: db},
+		Exams:        ExamModel{DB: db},
 	}
 }
@@ -26,6 +26,34 @@ type CustomPrivilegeAccess struct {
 	Carryovers bool
 	Exempted   bool
 	Marks      bool
+	// MarkSubjects narrows Marks down to the subjects the user was granted.
+	MarkSubjects *SubjectAccess
+}
+
+// SubjectAccess is the set of subjects of a table a user was granted. A stage-wide
+// grant (subject_id -1) sets All; otherwise only SubjectIds are allowed.
+type SubjectAccess struct {
+	All        bool
+	SubjectIds []int64
+}
+
+// Allows reports whether the subject is covered by the access. A nil access allows
+// every subject.
+func (a *SubjectAccess) Allows(subjectId int64) bool {
+	if a == nil || a.All {
+		return true
+	}
+	for _, id := range a.SubjectIds {
+		if id == subjectId {
+			return true
+		}
+	}
+	return false
+}
+
+// None reports whether no subject at all is covered.
+func (a *SubjectAccess) None() bool {
+	return a != nil && !a.All && len(a.SubjectIds) == 0
 }
 
 func ValidatePrivilege(v *validator.Validator, privilege *Privilege) {
@@ -68,6 +96,25 @@ func (p PrivilegeModel) Insert(privilege *Privilege) error {
 	return p.DB.QueryRowContext(ctx, query, args...).Scan(&privilege.CreatedAt)
 }
 
+// copyPrivileges grants every privilege bound to a table of the from year on the
+// matching table of the to year, e.g. marks_2023_2024 -> marks_2024_2025. It returns
+// the number of privileges created.
+func copyPrivileges(ctx context.Context, db execer, from, to string) (int64, error) {
+	query := `
+	INSERT INTO privileges (user_id, year, table_id, stage, subject_id, can_read, can_write)
+	SELECT p.user_id, $2, nt.id, p.stage, p.subject_id, p.can_read, p.can_write
+	FROM privileges p
+	JOIN tables ot ON p.table_id = ot.id
+	JOIN tables nt ON nt.table_name = left(ot.table_name, length(ot.table_name) - length($1)) || $2
+	WHERE p.year = $1 AND right(ot.table_name, length($1) + 1) = '_' || $1
+	ON CONFLICT (user_id, year, table_id, stage, subject_id) DO NOTHING`
+	result, err := db.ExecContext(ctx, query, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (p PrivilegeModel) GetAll(userId int) ([]*Privilege, error) {
 	query := `
 	SELECT p.user_id, p.year, p.table_id, t.table_name as table_name, p.stage, p.subject_id,
@@ -105,9 +152,13 @@ func (p PrivilegeModel) GetAll(userId int) ([]*Privilege, error) {
 	return privileges, nil
 }
 
+// Delete removes the user's privilege on the table and stage. A SubjectId of 0 removes
+// it for every subject, otherwise only the grant with that SubjectId is removed.
 func (p PrivilegeModel) Delete(privilege *Privilege) error {
-	query := `DELETE FROM privileges WHERE user_id = $1 AND year = $2 AND table_id = $3 AND stage = $4`
-	args := []interface{}{privilege.UserId, privilege.Year, privilege.TableId, privilege.Stage}
+	query := `
+	DELETE FROM privileges
+	WHERE user_id = $1 AND year = $2 AND table_id = $3 AND stage = $4 AND ($5 = 0 OR subject_id = $5)`
+	args := []interface{}{privilege.UserId, privilege.Year, privilege.TableId, privilege.Stage, privilege.SubjectId}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	result, err := p.DB.ExecContext(ctx, query, args...)
@@ -126,10 +177,11 @@ func (p PrivilegeModel) Delete(privilege *Privilege) error {
 
 func (p PrivilegeModel) CheckAccess(userId int, tableName, stage string) (*Privilege, error) {
 	query := `
-	SELECT p.user_id, t.table_name as table_name, p.stage, p.can_read, p.can_write
-	FROM privileges p
-	JOIN tables t ON p.table_id = t.id
-	WHERE p.user_id = $1 AND t.table_name = $2 AND (p.stage = $3 OR p.stage = 'all')
+	SELECT p.user_id, p.table_name, p.stage, p.can_read, p.can_write
+	FROM effective_privileges p
+	WHERE p.user_id = $1 AND p.table_name = $2 AND (p.stage = $3 OR p.stage = 'all')
+	AND p.subject_id = -1
+	ORDER BY p.can_read DESC, p.can_write DESC
 	LIMIT 1`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -185,9 +237,10 @@ func (p PrivilegeModel) CheckAccess(userId int, tableName, stage string) (*Privi
 func (p PrivilegeModel) CheckWriteAccess(userId int, tableName, stage string) (bool, error) {
 	query := `
 	SELECT p.can_write
-	FROM privileges p
-	JOIN tables t ON p.table_id = t.id
-	WHERE p.user_id = $1 AND t.table_name = $2 AND (p.stage = $3 OR p.stage = 'all')
+	FROM effective_privileges p
+	WHERE p.user_id = $1 AND p.table_name = $2 AND (p.stage = $3 OR p.stage = 'all')
+	AND p.subject_id = -1
+	ORDER BY p.can_write DESC
 	LIMIT 1`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -209,13 +262,97 @@ func (p PrivilegeModel) CheckWriteAccess(userId int, tableName, stage string) (b
 	return false, nil
 }
 
+// GetSubjectAccess returns the subjects of the table the user may read (or write, when
+// write is set) in the stage. Subject-scoped grants apply whatever stage they were
+// recorded under, since the subject already determines it.
+func (p PrivilegeModel) GetSubjectAccess(userId int, tableName, stage string, write bool) (*SubjectAccess, error) {
+	query := `
+	SELECT DISTINCT p.subject_id
+	FROM effective_privileges p
+	WHERE p.user_id = $1 AND p.table_name = $2
+	AND (p.subject_id <> -1 OR p.stage = $3 OR p.stage = 'all')
+	AND CASE WHEN $4 THEN p.can_write ELSE p.can_read END`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := p.DB.QueryContext(ctx, query, userId, tableName, stage, write)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	access := &SubjectAccess{}
+	for rows.Next() {
+		var subjectId int64
+		err := rows.Scan(&subjectId)
+		if err != nil {
+			return nil, err
+		}
+		if subjectId == -1 {
+			access.All = true
+		} else {
+			access.SubjectIds = append(access.SubjectIds, subjectId)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return access, nil
+}
+
+// CheckSubjectWriteAccess is like CheckWriteAccess but also accepts a grant scoped to
+// the subject.
+func (p PrivilegeModel) CheckSubjectWriteAccess(userId int, tableName, stage string, subjectId int64) (bool, error) {
+	access, err := p.GetSubjectAccess(userId, tableName, stage, true)
+	if err != nil {
+		return false, err
+	}
+	return access.Allows(subjectId), nil
+}
+
+// ReadableStages lists the stages of the table the user can read, in order. A grant
+// on all stages returns every stage.
+func (p PrivilegeModel) ReadableStages(userId int, tableName string) ([]string, error) {
+	query := `
+	SELECT DISTINCT p.stage
+	FROM effective_privileges p
+	WHERE p.user_id = $1 AND p.table_name = $2 AND p.subject_id = -1 AND p.can_read`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := p.DB.QueryContext(ctx, query, userId, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	granted := make(map[string]bool)
+	for rows.Next() {
+		var stage string
+		err := rows.Scan(&stage)
+		if err != nil {
+			return nil, err
+		}
+		granted[stage] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var stages []string
+	for _, stage := range Stages {
+		if granted["all"] || granted[stage] {
+			stages = append(stages, stage)
+		}
+	}
+	return stages, nil
+}
+
 func (p PrivilegeModel) CheckCustomAccess(userId int, year, stage string) (*CustomPrivilegeAccess, error) {
 	query := `
 	SELECT p.can_read
-	FROM privileges p
-	JOIN tables t ON p.table_id = t.id
-	WHERE p.user_id = $1 AND t.table_name = $2 AND (p.stage = $3 OR p.stage = 'all')
-	`
+	FROM effective_privileges p
+	WHERE p.user_id = $1 AND p.table_name = $2 AND (p.stage = $3 OR p.stage = 'all')
+	AND p.subject_id = -1
+	ORDER BY p.can_read DESC
+	LIMIT 1`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	var access CustomPrivilegeAccess
@@ -259,25 +396,20 @@ func (p PrivilegeModel) CheckCustomAccess(userId int, year, stage string) (*Cust
 			return nil, err
 		}
 	}
-	table = "marks_" + year
-	err = p.DB.QueryRowContext(ctx, query, userId, table, stage).Scan(&access.Marks)
+	access.MarkSubjects, err = p.GetSubjectAccess(userId, "marks_"+year, stage, false)
 	if err != nil {
-		switch {
-		case err == sql.ErrNoRows:
-			access.Marks = false
-		default:
-			return nil, err
-		}
+		return nil, err
 	}
+	access.Marks = !access.MarkSubjects.None()
 	return &access, nil
 }
 
 func (p PrivilegeModel) CheckUserReadAccess(userId int, table string) (bool, error) {
 	query := `
 	SELECT p.can_read
-	FROM privileges p
-	JOIN tables t ON p.table_id = t.id
-	WHERE p.user_id = $1 AND t.table_name = $2
+	FROM effective_privileges p
+	WHERE p.user_id = $1 AND p.table_name = $2
+	ORDER BY p.can_read DESC
 	LIMIT 1`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -302,9 +434,9 @@ func (p PrivilegeModel) CheckUserReadAccess(userId int, table string) (bool, err
 func (p PrivilegeModel) CheckUserWriteAccess(userId int, table string) (bool, error) {
 	query := `
 	SELECT p.can_write
-	FROM privileges p
-	JOIN tables t ON p.table_id = t.id
-	WHERE p.user_id = $1 AND t.table_name = $2
+	FROM effective_privileges p
+	WHERE p.user_id = $1 AND p.table_name = $2
+	ORDER BY p.can_write DESC
 	LIMIT 1`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
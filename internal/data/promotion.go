@@ -0,0 +1,383 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"collegecm.hamid.net/internal/validator"
+)
+
+var ErrNextYearMissing = errors.New("next academic year has not been created")
+
+// Stages lists the stage names in order, first stage first.
+var Stages = []string{"الاولى", "الثانية", "الثالثة", "الرابعة", "الخامسة", "السادسة"}
+
+// The decisions a promotion can reach for a student.
+const (
+	DecisionPromoted  = "promoted"
+	DecisionCarryover = "promoted_with_carryovers"
+	DecisionRepeat    = "repeat"
+	DecisionGraduated = "graduated"
+	DecisionSkipped   = "skipped"
+)
+
+// PromotionRules holds the configurable end-of-year rules.
+type PromotionRules struct {
+	// MaxCarryovers is the most failed subjects a student can carry into the next
+	// stage; failing more means repeating the year.
+	MaxCarryovers int
+	// FinalStage is the number of the last stage (e.g. 6 for السادسة).
+	FinalStage int
+	// ExcludedStates are student states that aren't promoted at all.
+	ExcludedStates []string
+}
+
+type PromotionOutcome struct {
+	StudentId      int64    `json:"student_id"`
+	StudentName    string   `json:"student_name"`
+	FromStage      string   `json:"from_stage"`
+	ToStage        string   `json:"to_stage,omitempty"`
+	Decision       string   `json:"decision"`
+	FailedSubjects []string `json:"failed_subjects,omitempty"`
+	AlreadyPresent bool     `json:"already_present,omitempty"`
+	failedIds      []int64
+	student        *Student
+}
+
+type PromotionReport struct {
+	FromYear  string              `json:"from_year"`
+	ToYear    string              `json:"to_year"`
+	DryRun    bool                `json:"dry_run"`
+	Committed bool                `json:"committed"`
+	Summary   map[string]int      `json:"summary"`
+	Outcomes  []*PromotionOutcome `json:"outcomes"`
+	Errors    []string            `json:"errors"`
+	// Students and Carryovers are the rows written into the next year, for the audit log.
+	Students   []*Student   `json:"-"`
+	Carryovers []*Carryover `json:"-"`
+}
+
+// NextAcademicYear returns the year after the given YYYY_YYYY year.
+func NextAcademicYear(year string) (string, error) {
+	if !isValidAcademicYear(year) {
+		return "", errors.New("invalid year")
+	}
+	first, _ := strconv.Atoi(year[:4])
+	return fmt.Sprintf("%d_%d", first+1, first+2), nil
+}
+
+type PromotionModel struct {
+	DB *sql.DB
+}
+
+// Promote evaluates every student of the year and, unless dryRun is set or the
+// evaluation reported errors, writes the results into the next year's students and
+// carryovers tables in a single transaction. Students already present in the next
+// year are left untouched, so running the job again is harmless.
+func (m PromotionModel) Promote(year string, policy GradePolicy, rules PromotionRules, dryRun bool) (*PromotionReport, error) {
+	next, err := NextAcademicYear(year)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM years WHERE year = $1)`, next).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNextYearMissing
+	}
+	records, err := loadYearRecords(ctx, tx, year)
+	if err != nil {
+		return nil, err
+	}
+	nextSubjects, err := loadSubjectIds(ctx, tx, next)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := loadStudentIds(ctx, tx, next)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PromotionReport{
+		FromYear: year,
+		ToYear:   next,
+		DryRun:   dryRun,
+		Summary:  make(map[string]int),
+		Outcomes: []*PromotionOutcome{},
+		Errors:   []string{},
+	}
+	for _, student := range records.students {
+		outcome := evaluateStudent(student, records, policy, rules)
+		report.Outcomes = append(report.Outcomes, outcome)
+		report.Summary[outcome.Decision]++
+		if existing[outcome.StudentId] {
+			outcome.AlreadyPresent = true
+			continue
+		}
+		for _, subjectId := range outcome.failedIds {
+			if outcome.Decision == DecisionCarryover && !nextSubjects[subjectId] {
+				report.Errors = append(report.Errors, fmt.Sprintf("المادة %d غير موجودة في سنة %s (الطالب %d)", subjectId, next, outcome.StudentId))
+			}
+		}
+	}
+	report.sortOutcomes()
+	if dryRun || len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	studentsQ := fmt.Sprintf(`
+	INSERT INTO students_%s (student_name, stage, student_id, state)
+	VALUES ($1, $2, $3, $4)
+	RETURNING seq_in_college, created_at`, next)
+	carryoversQ := fmt.Sprintf(`
+	INSERT INTO carryovers_%s (student_id, subject_id)
+	VALUES ($1, $2)
+	RETURNING id, created_at`, next)
+	for _, outcome := range report.Outcomes {
+		if outcome.ToStage == "" || outcome.AlreadyPresent {
+			continue
+		}
+		student := &Student{
+			StudentName: outcome.student.StudentName,
+			Stage:       outcome.ToStage,
+			StudentId:   outcome.student.StudentId,
+			State:       outcome.student.State,
+		}
+		err = tx.QueryRowContext(ctx, studentsQ, student.StudentName, student.Stage, student.StudentId, student.State).Scan(&student.SeqInCollege, &student.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		report.Students = append(report.Students, student)
+		if outcome.Decision != DecisionCarryover {
+			continue
+		}
+		for _, subjectId := range outcome.failedIds {
+			carryover := &Carryover{StudentId: int64(student.StudentId), SubjectId: subjectId, StudentName: student.StudentName}
+			err = tx.QueryRowContext(ctx, carryoversQ, carryover.StudentId, carryover.SubjectId).Scan(&carryover.Id, &carryover.CreatedAt)
+			if err != nil {
+				return nil, err
+			}
+			report.Carryovers = append(report.Carryovers, carryover)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	report.Committed = true
+	return report, nil
+}
+
+func evaluateStudent(student *Student, records *yearRecords, policy GradePolicy, rules PromotionRules) *PromotionOutcome {
+	outcome := &PromotionOutcome{
+		StudentId:   int64(student.StudentId),
+		StudentName: student.StudentName,
+		FromStage:   student.Stage,
+		student:     student,
+	}
+	stageNumber := StageNumber(student.Stage)
+	if validator.In(student.State, rules.ExcludedStates...) || stageNumber == 0 {
+		outcome.Decision = DecisionSkipped
+		return outcome
+	}
+	key := func(subjectId int64) [2]int64 { return [2]int64{int64(student.StudentId), subjectId} }
+	// the student must pass every active subject of their stage they aren't exempted
+	// from, plus every subject they carried over into this year.
+	var required []*Subject
+	for _, subject := range records.subjects {
+		id := int64(subject.ID)
+		switch {
+		case records.carryovers[key(id)]:
+			required = append(required, subject)
+		case subject.Stage == student.Stage && subject.Active != "لا" && !records.exempted[key(id)]:
+			required = append(required, subject)
+		}
+	}
+	for _, subject := range required {
+		id := int64(subject.ID)
+		mark, ok := records.marks[key(id)]
+		if ok {
+			mark.MaxSemesterMark = subject.MaxSemesterMark
+			mark.MaxFinalExam = subject.MaxFinalExam
+			policy.Grade(mark)
+		}
+		if !ok || !mark.Passed {
+			outcome.failedIds = append(outcome.failedIds, id)
+			outcome.FailedSubjects = append(outcome.FailedSubjects, subject.SubjectName)
+		}
+	}
+	failed := len(outcome.failedIds)
+	switch {
+	case failed == 0 && stageNumber >= rules.FinalStage:
+		outcome.Decision = DecisionGraduated
+	case failed == 0:
+		outcome.Decision = DecisionPromoted
+		outcome.ToStage = Stages[stageNumber]
+	case failed <= rules.MaxCarryovers && stageNumber < rules.FinalStage:
+		outcome.Decision = DecisionCarryover
+		outcome.ToStage = Stages[stageNumber]
+	default:
+		outcome.Decision = DecisionRepeat
+		outcome.ToStage = student.Stage
+	}
+	return outcome
+}
+
+// StageNumber returns the 1-based position of the stage name, or 0 if unknown.
+func StageNumber(stage string) int {
+	for i, name := range Stages {
+		if name == stage {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// yearRecords holds everything the promotion needs from one year, keyed by
+// (student_id, subject_id) where that applies.
+type yearRecords struct {
+	students   []*Student
+	subjects   []*Subject
+	marks      map[[2]int64]*Mark
+	exempted   map[[2]int64]bool
+	carryovers map[[2]int64]bool
+}
+
+func loadYearRecords(ctx context.Context, tx *sql.Tx, year string) (*yearRecords, error) {
+	records := &yearRecords{
+		marks:      make(map[[2]int64]*Mark),
+		exempted:   make(map[[2]int64]bool),
+		carryovers: make(map[[2]int64]bool),
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+	SELECT student_name, stage, student_id, state FROM students_%s ORDER BY student_id`, year))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var student Student
+		err = rows.Scan(&student.StudentName, &student.Stage, &student.StudentId, &student.State)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		records.students = append(records.students, &student)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, fmt.Sprintf(`
+	SELECT subject_id, subject_name, stage, max_semester_mark, max_final_exam, credits, active
+	FROM subjects_%s ORDER BY subject_id`, year))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var subject Subject
+		err = rows.Scan(&subject.ID, &subject.SubjectName, &subject.Stage, &subject.MaxSemesterMark, &subject.MaxFinalExam, &subject.Credits, &subject.Active)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		records.subjects = append(records.subjects, &subject)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, fmt.Sprintf(`
+	SELECT student_id, subject_id, semester_mark, final_mark, second_final_mark FROM marks_%s`, year))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var mark Mark
+		err = rows.Scan(&mark.StudentId, &mark.SubjectId, &mark.SemesterMark, &mark.FinalMark, &mark.SecondFinalMark)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		records.marks[[2]int64{mark.StudentId, mark.SubjectId}] = &mark
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for table, set := range map[string]map[[2]int64]bool{"exempted": records.exempted, "carryovers": records.carryovers} {
+		rows, err = tx.QueryContext(ctx, fmt.Sprintf(`SELECT student_id, subject_id FROM %s_%s`, table, year))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var pair [2]int64
+			err = rows.Scan(&pair[0], &pair[1])
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			set[pair] = true
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func loadSubjectIds(ctx context.Context, tx *sql.Tx, year string) (map[int64]bool, error) {
+	return loadIdSet(ctx, tx, fmt.Sprintf(`SELECT subject_id FROM subjects_%s`, year))
+}
+
+func loadStudentIds(ctx context.Context, tx *sql.Tx, year string) (map[int64]bool, error) {
+	return loadIdSet(ctx, tx, fmt.Sprintf(`SELECT student_id FROM students_%s`, year))
+}
+
+func loadIdSet(ctx context.Context, tx *sql.Tx, query string) (map[int64]bool, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// sortOutcomes orders a report's outcomes by decision, then student name.
+func (r *PromotionReport) sortOutcomes() {
+	order := map[string]int{DecisionPromoted: 0, DecisionCarryover: 1, DecisionRepeat: 2, DecisionGraduated: 3, DecisionSkipped: 4}
+	sort.SliceStable(r.Outcomes, func(i, j int) bool {
+		a, b := r.Outcomes[i], r.Outcomes[j]
+		if order[a.Decision] != order[b.Decision] {
+			return order[a.Decision] < order[b.Decision]
+		}
+		return strings.Compare(a.StudentName, b.StudentName) < 0
+	})
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"sort"
+
+	"collegecm.hamid.net/internal/validator"
+)
+
+// Student states that, by default, take a student out of the class order.
+const (
+	StateWithdrawn = "منسحب"
+	StatePostponed = "مؤجل"
+)
+
+// StudentRank is a student's position (تسلسل الطالب) in the class order.
+type StudentRank struct {
+	Rank int `json:"rank"`
+	*StudentAverage
+}
+
+// Rank orders the students for the official class order, leaving out any student whose
+// state is in excludedStates. Students are ordered by average (highest first), then
+// by fewest failed subjects, then by most credits counted; students equal on all three
+// share a rank and are listed by name. Ranks skip after ties (1, 1, 3).
+func Rank(averages []*StudentAverage, excludedStates []string) []*StudentRank {
+	ranks := []*StudentRank{}
+	for _, average := range averages {
+		if validator.In(average.State, excludedStates...) {
+			continue
+		}
+		ranks = append(ranks, &StudentRank{StudentAverage: average})
+	}
+	sort.SliceStable(ranks, func(i, j int) bool {
+		a, b := ranks[i], ranks[j]
+		if a.Average != b.Average {
+			return a.Average > b.Average
+		}
+		if a.Failed != b.Failed {
+			return a.Failed < b.Failed
+		}
+		if a.Credits != b.Credits {
+			return a.Credits > b.Credits
+		}
+		if a.StudentName != b.StudentName {
+			return a.StudentName < b.StudentName
+		}
+		return a.StudentId < b.StudentId
+	})
+	for i, rank := range ranks {
+		rank.Rank = i + 1
+		if i > 0 {
+			prev := ranks[i-1]
+			if prev.Average == rank.Average && prev.Failed == rank.Failed && prev.Credits == rank.Credits {
+				rank.Rank = prev.Rank
+			}
+		}
+	}
+	return ranks
+}
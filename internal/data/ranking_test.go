@@ -0,0 +1,110 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		name     string
+		averages []*StudentAverage
+		excluded []string
+		want     []int64 // student ids in class order
+		ranks    []int
+	}{
+		{
+			name:     "no students",
+			averages: nil,
+			want:     []int64{},
+			ranks:    []int{},
+		},
+		{
+			name: "by average",
+			averages: []*StudentAverage{
+				{StudentId: 1, StudentName: "أحمد", Average: 70},
+				{StudentId: 2, StudentName: "باسم", Average: 90},
+				{StudentId: 3, StudentName: "جاسم", Average: 80},
+			},
+			want:  []int64{2, 3, 1},
+			ranks: []int{1, 2, 3},
+		},
+		{
+			name: "ties share a rank and skip the next",
+			averages: []*StudentAverage{
+				{StudentId: 1, StudentName: "جاسم", Average: 80, Credits: 30},
+				{StudentId: 2, StudentName: "أحمد", Average: 80, Credits: 30},
+				{StudentId: 3, StudentName: "باسم", Average: 70, Credits: 30},
+			},
+			want:  []int64{2, 1, 3},
+			ranks: []int{1, 1, 3},
+		},
+		{
+			name: "tie broken by fewer failed subjects",
+			averages: []*StudentAverage{
+				{StudentId: 1, StudentName: "أحمد", Average: 80, Failed: 1, Credits: 30},
+				{StudentId: 2, StudentName: "باسم", Average: 80, Failed: 0, Credits: 30},
+			},
+			want:  []int64{2, 1},
+			ranks: []int{1, 2},
+		},
+		{
+			name: "tie broken by more credits",
+			averages: []*StudentAverage{
+				{StudentId: 1, StudentName: "أحمد", Average: 80, Credits: 28},
+				{StudentId: 2, StudentName: "باسم", Average: 80, Credits: 30},
+			},
+			want:  []int64{2, 1},
+			ranks: []int{1, 2},
+		},
+		{
+			name: "same name ordered by id",
+			averages: []*StudentAverage{
+				{StudentId: 5, StudentName: "أحمد", Average: 80},
+				{StudentId: 4, StudentName: "أحمد", Average: 80},
+			},
+			want:  []int64{4, 5},
+			ranks: []int{1, 1},
+		},
+		{
+			name: "three-way tie",
+			averages: []*StudentAverage{
+				{StudentId: 1, StudentName: "أحمد", Average: 90},
+				{StudentId: 2, StudentName: "باسم", Average: 80},
+				{StudentId: 3, StudentName: "جاسم", Average: 80},
+				{StudentId: 4, StudentName: "حسن", Average: 80},
+				{StudentId: 5, StudentName: "خالد", Average: 60},
+			},
+			want:  []int64{1, 2, 3, 4, 5},
+			ranks: []int{1, 2, 2, 2, 5},
+		},
+		{
+			name: "excluded states are left out",
+			averages: []*StudentAverage{
+				{StudentId: 1, StudentName: "أحمد", Average: 90, State: StateWithdrawn},
+				{StudentId: 2, StudentName: "باسم", Average: 80},
+				{StudentId: 3, StudentName: "جاسم", Average: 85, State: StatePostponed},
+			},
+			excluded: []string{StateWithdrawn, StatePostponed},
+			want:     []int64{2},
+			ranks:    []int{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranks := Rank(tt.averages, tt.excluded)
+			ids := []int64{}
+			got := []int{}
+			for _, rank := range ranks {
+				ids = append(ids, rank.StudentId)
+				got = append(got, rank.Rank)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("order = %v, want %v", ids, tt.want)
+			}
+			if !reflect.DeepEqual(got, tt.ranks) {
+				t.Errorf("ranks = %v, want %v", got, tt.ranks)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"collegecm.hamid.net/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Role bundles table grants that are handed out together. Assigning a role to a user
+// binds it to a year and stage, see UserRole.
+type Role struct {
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Grants      []*RoleGrant `json:"grants"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// RoleGrant gives read and/or write on one table. TableName is one of YearTables,
+// applied to the assignment's year. Global tables can't be granted through a role,
+// whoever may write roles could otherwise grant themselves users or privileges write;
+// they're granted one user at a time as privileges. An empty Stage takes the stage of
+// the assignment.
+type RoleGrant struct {
+	TableName string `json:"table_name"`
+	Stage     string `json:"stage"`
+	CanRead   bool   `json:"can_read"`
+	CanWrite  bool   `json:"can_write"`
+}
+
+// UserRole assigns a role to a user for a year and stage, either of which may be
+// "all". SubjectId, when not -1, limits the role's marks and attendance grants to that
+// subject.
+type UserRole struct {
+	UserId    int       `json:"user_id"`
+	RoleId    int64     `json:"role_id"`
+	RoleName  string    `json:"role_name"`
+	Year      string    `json:"year"`
+	Stage     string    `json:"stage"`
+	SubjectId int       `json:"subject_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "الاسم", "يجب تزويد المعلومات")
+	v.Check(len(role.Name) <= 100, "الاسم", "يجب ان لا يزيد عن 100 حرف")
+	v.Check(len(role.Description) <= 255, "الوصف", "يجب ان لا يزيد عن 255 حرف")
+	seen := make(map[string]bool)
+	for _, grant := range role.Grants {
+		v.Check(validator.In(grant.TableName, YearTables...), "الجدول", "لا يمكن منح هذا الجدول ضمن دور: "+grant.TableName)
+		v.Check(grant.Stage == "" || grant.Stage == "all" || validator.In(grant.Stage, Stages...), "المرحلة", "مرحلة غير معروفة: "+grant.Stage)
+		key := grant.TableName + "/" + grant.Stage
+		v.Check(!seen[key], "الصلاحيات", "الجدول مكرر: "+grant.TableName)
+		seen[key] = true
+	}
+}
+
+func ValidateUserRole(v *validator.Validator, userRole *UserRole) {
+	v.Check(userRole.UserId > 0, "المستخدم", "يجب تزويد المعلومات")
+	v.Check(userRole.RoleId > 0, "الدور", "يجب تزويد المعلومات")
+	v.Check(userRole.Year != "", "السنة", "يجب تزويد المعلومات")
+	v.Check(userRole.Stage == "all" || validator.In(userRole.Stage, Stages...), "المرحلة", "يجب تزويد المعلومات")
+	v.Check(userRole.SubjectId == -1 || userRole.SubjectId > 0, "المادة", "يجب تزويد المعلومات")
+	v.Check(userRole.SubjectId == -1 || userRole.Year != "all", "المادة", "يجب تحديد السنة عند تحديد المادة")
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the role together with its grants.
+func (m RoleModel) Insert(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+	INSERT INTO roles (name, description)
+	VALUES ($1, $2)
+	RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.ID, &role.CreatedAt)
+	if err != nil {
+		return roleError(err)
+	}
+	err = insertGrants(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update saves the role's name and description and replaces its grants.
+func (m RoleModel) Update(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+	UPDATE roles SET name = $1, description = $2
+	WHERE id = $3`
+	result, err := tx.ExecContext(ctx, query, role.Name, role.Description, role.ID)
+	if err != nil {
+		return roleError(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM role_grants WHERE role_id = $1`, role.ID)
+	if err != nil {
+		return err
+	}
+	err = insertGrants(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertGrants(ctx context.Context, tx *sql.Tx, role *Role) error {
+	query := `
+	INSERT INTO role_grants (role_id, table_name, stage, can_read, can_write)
+	VALUES ($1, $2, $3, $4, $5)`
+	for _, grant := range role.Grants {
+		_, err := tx.ExecContext(ctx, query, role.ID, grant.TableName, grant.Stage, grant.CanRead, grant.CanWrite)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m RoleModel) Get(id int64) (*Role, error) {
+	query := `SELECT id, name, description, created_at FROM roles WHERE id = $1`
+	var role Role
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&role.ID, &role.Name, &role.Description, &role.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	grants, err := m.getGrants(ctx, []int64{role.ID})
+	if err != nil {
+		return nil, err
+	}
+	role.Grants = grants[role.ID]
+	if role.Grants == nil {
+		role.Grants = []*RoleGrant{}
+	}
+	return &role, nil
+}
+
+// GetAll returns every role with its grants.
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `SELECT id, name, description, created_at FROM roles ORDER BY name`
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	var ids []int64
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		role.Grants = []*RoleGrant{}
+		roles = append(roles, &role)
+		ids = append(ids, role.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	grants, err := m.getGrants(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if g, ok := grants[role.ID]; ok {
+			role.Grants = g
+		}
+	}
+	return roles, nil
+}
+
+func (m RoleModel) getGrants(ctx context.Context, roleIds []int64) (map[int64][]*RoleGrant, error) {
+	query := `
+	SELECT role_id, table_name, stage, can_read, can_write
+	FROM role_grants
+	WHERE role_id = ANY($1)
+	ORDER BY table_name, stage`
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(roleIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := make(map[int64][]*RoleGrant)
+	for rows.Next() {
+		var roleId int64
+		var grant RoleGrant
+		err := rows.Scan(&roleId, &grant.TableName, &grant.Stage, &grant.CanRead, &grant.CanWrite)
+		if err != nil {
+			return nil, err
+		}
+		grants[roleId] = append(grants[roleId], &grant)
+	}
+	return grants, rows.Err()
+}
+
+// Delete removes the role; its grants and assignments go with it.
+func (m RoleModel) Delete(id int64) error {
+	query := `DELETE FROM roles WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Assign gives the role to the user. Assigning it again is a no-op.
+func (m RoleModel) Assign(userRole *UserRole) error {
+	query := `
+	INSERT INTO user_roles (user_id, role_id, year, stage, subject_id)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (user_id, role_id, year, stage, subject_id) DO UPDATE
+	SET user_id = EXCLUDED.user_id
+	RETURNING created_at`
+	args := []interface{}{userRole.UserId, userRole.RoleId, userRole.Year, userRole.Stage, userRole.SubjectId}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&userRole.CreatedAt)
+}
+
+func (m RoleModel) Unassign(userRole *UserRole) error {
+	query := `
+	DELETE FROM user_roles
+	WHERE user_id = $1 AND role_id = $2 AND year = $3 AND stage = $4 AND subject_id = $5`
+	args := []interface{}{userRole.UserId, userRole.RoleId, userRole.Year, userRole.Stage, userRole.SubjectId}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAssignments lists the roles assigned to the user.
+func (m RoleModel) GetAssignments(userId int) ([]*UserRole, error) {
+	query := `
+	SELECT ur.user_id, ur.role_id, r.name, ur.year, ur.stage, ur.subject_id, ur.created_at
+	FROM user_roles ur
+	JOIN roles r ON ur.role_id = r.id
+	WHERE ur.user_id = $1
+	ORDER BY ur.year, r.name`
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userRoles := []*UserRole{}
+	for rows.Next() {
+		var userRole UserRole
+		err := rows.Scan(
+			&userRole.UserId,
+			&userRole.RoleId,
+			&userRole.RoleName,
+			&userRole.Year,
+			&userRole.Stage,
+			&userRole.SubjectId,
+			&userRole.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		userRoles = append(userRoles, &userRole)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return userRoles, nil
+}
+
+// roleError maps a unique violation on the role name to ErrDuplicateRole.
+func roleError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicateRole
+	}
+	return err
+}
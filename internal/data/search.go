@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// arabicFolds are the letters that are written interchangeably in names and are
+// searched as one: the alef variants, ta marbuta and alef maqsura.
+var arabicFolds = []struct{ from, to rune }{
+	{'أ', 'ا'},
+	{'إ', 'ا'},
+	{'آ', 'ا'},
+	{'ٱ', 'ا'},
+	{'ة', 'ه'},
+	{'ى', 'ي'},
+}
+
+// arabicMarks are the diacritics (tashkeel) and the tatweel, which are ignored.
+const arabicMarks = "\u064b\u064c\u064d\u064e\u064f\u0650\u0651\u0652\u0670\u0640"
+
+// NormalizeArabic folds s for searching: letters in arabicFolds are replaced, the
+// marks removed, Latin letters lower-cased and runs of spaces collapsed.
+func NormalizeArabic(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(arabicMarks, r) {
+			return -1
+		}
+		for _, fold := range arabicFolds {
+			if r == fold.from {
+				return fold.to
+			}
+		}
+		return r
+	}, strings.ToLower(s))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// normalizedColumn returns the SQL expression NormalizeArabic applies to column, so
+// stored names are compared in the same form as the search query.
+func normalizedColumn(column string) string {
+	var from, to strings.Builder
+	for _, fold := range arabicFolds {
+		from.WriteRune(fold.from)
+		to.WriteRune(fold.to)
+	}
+	// characters without a counterpart in to are removed by translate.
+	from.WriteString(arabicMarks)
+	return fmt.Sprintf("translate(lower(%s), '%s', '%s')", column, from.String(), to.String())
+}
+
+// SearchHit is a student or subject found by a search. Score ranks the hits: an exact
+// id or name scores highest, then names starting with the query, then names with a
+// word starting with it, then names merely containing every word of the query.
+type SearchHit struct {
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+	Stage string `json:"stage"`
+	State string `json:"state,omitempty"`
+	Score int    `json:"score"`
+}
+
+type SearchModel struct {
+	DB *sql.DB
+}
+
+// Students searches the year's students of the given stages by name and student id.
+func (m SearchModel) Students(year, q string, stages []string, limit int) ([]*SearchHit, error) {
+	return m.search(fmt.Sprintf("students_%s", year), "student_id", "state", []string{"student_name"}, q, stages, limit)
+}
+
+// Subjects searches the year's subjects of the given stages by their Arabic and English
+// names and subject id.
+func (m SearchModel) Subjects(year, q string, stages []string, limit int) ([]*SearchHit, error) {
+	return m.search(fmt.Sprintf("subjects_%s", year), "subject_id", "''", []string{"subject_name", "subject_name_english"}, q, stages, limit)
+}
+
+func (m SearchModel) search(table, idColumn, stateColumn string, nameColumns []string, q string, stages []string, limit int) ([]*SearchHit, error) {
+	q = NormalizeArabic(q)
+	if q == "" {
+		return nil, errors.New("empty search query")
+	}
+	if len(stages) == 0 {
+		return []*SearchHit{}, nil
+	}
+	args := []interface{}{q, escapeLike(q)}
+	// $1 is the query, $2 the query escaped for LIKE.
+	var scores []string
+	if _, err := strconv.ParseInt(q, 10, 64); err == nil {
+		scores = append(scores, fmt.Sprintf(`CASE
+			WHEN %[1]s::text = $1::text THEN 100
+			WHEN %[1]s::text LIKE $2::text || '%%' THEN 60
+			ELSE 0 END`, idColumn))
+	}
+	var tokens []string
+	for _, token := range strings.Fields(q) {
+		args = append(args, "%"+escapeLike(token)+"%")
+		tokens = append(tokens, fmt.Sprintf("$%d", len(args)))
+	}
+	for _, column := range nameColumns {
+		name := normalizedColumn(column)
+		var contains []string
+		for _, token := range tokens {
+			contains = append(contains, fmt.Sprintf("%s LIKE %s", name, token))
+		}
+		scores = append(scores, fmt.Sprintf(`CASE
+			WHEN %[1]s = $1::text THEN 90
+			WHEN %[1]s LIKE $2::text || '%%' THEN 70
+			WHEN ' ' || %[1]s LIKE '%% ' || $2::text || '%%' THEN 50
+			WHEN %[2]s THEN 30
+			ELSE 0 END`, name, strings.Join(contains, " AND ")))
+	}
+	score := scores[0]
+	if len(scores) > 1 {
+		score = "GREATEST(" + strings.Join(scores, ", ") + ")"
+	}
+	args = append(args, pq.Array(stages), limit)
+	query := fmt.Sprintf(`
+	SELECT id, name, stage, state, score FROM (
+		SELECT %s AS id, %s AS name, stage, %s AS state, %s AS score
+		FROM %s
+		WHERE stage = ANY($%d)
+	) hits
+	WHERE score > 0
+	ORDER BY score DESC, name, id
+	LIMIT $%d`, idColumn, nameColumns[0], stateColumn, score, table, len(args)-1, len(args))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []*SearchHit{}
+	for rows.Next() {
+		var hit SearchHit
+		err := rows.Scan(&hit.Id, &hit.Name, &hit.Stage, &hit.State, &hit.Score)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, &hit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
@@ -0,0 +1,34 @@
+package data
+
+import "testing"
+
+func TestNormalizeArabic(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"unchanged", "محمد علي", "محمد علي"},
+		{"alef with hamza above", "أحمد", "احمد"},
+		{"alef with hamza below", "إبراهيم", "ابراهيم"},
+		{"alef with madda", "آمنة", "امنه"},
+		{"alef wasla", "ٱلله", "الله"},
+		{"ta marbuta", "فاطمة", "فاطمه"},
+		{"alef maqsura", "مصطفى", "مصطفي"},
+		{"diacritics", "مُحَمَّد", "محمد"},
+		{"tanween", "كتابًا", "كتابا"},
+		{"tatweel", "محـــمد", "محمد"},
+		{"latin lower-cased", "Anatomy I", "anatomy i"},
+		{"spaces collapsed", "  علي   حسن ", "علي حسن"},
+		{"tabs and newlines", "علي\tحسن\n", "علي حسن"},
+		{"digits kept", "طالب 123", "طالب 123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeArabic(tt.in); got != tt.want {
+				t.Errorf("NormalizeArabic(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -57,29 +57,37 @@ func (m StudentModel) Insert(year string, student *Student) error {
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&student.CreatedAt, &student.SeqInCollege)
 }
 
-func (m StudentModel) GetAll(year, stage string) ([]*Student, error) {
+// GetAll lists the students of the stage that match the filters, a page at a time.
+// Name matches the student's name.
+func (m StudentModel) GetAll(year, stage string, filters Filters) ([]*Student, Metadata, error) {
 	if strings.TrimSpace(year) == "" {
-		return nil, errors.New("invalid year")
+		return nil, Metadata{}, errors.New("invalid year")
 	}
 	tableName := fmt.Sprintf("students_%s", year)
-	query := fmt.Sprintf("SELECT seq_in_college, student_name, stage, student_id, state, created_at FROM %s", tableName)
+	query := fmt.Sprintf("SELECT count(*) OVER(), seq_in_college, student_name, stage, student_id, state, created_at FROM %s WHERE 1 = 1", tableName)
 	var args []interface{}
 	if stage != "all" {
-		query += " WHERE stage = $1"
 		args = append(args, stage)
+		query += fmt.Sprintf(" AND stage = $%d", len(args))
 	}
+	conditions, args := filters.conditions(filterColumns{name: []string{"student_name"}, state: "state"}, args)
+	query += conditions + filters.orderBy("seq_in_college")
+	limit, args := filters.limitOffset(args)
+	query += limit
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	var students []*Student
 	for rows.Next() {
 		var student Student
 		err := rows.Scan(
+			&totalRecords,
 			&student.SeqInCollege,
 			&student.StudentName,
 			&student.Stage,
@@ -88,14 +96,14 @@ func (m StudentModel) GetAll(year, stage string) ([]*Student, error) {
 			&student.CreatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		students = append(students, &student)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
-	return students, nil
+	return students, calculateMetadata(totalRecords, filters), nil
 }
 
 func (m StudentModel) Get(year string, id int64) (*Student, error) {
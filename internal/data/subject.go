@@ -97,29 +97,42 @@ func (m SubjectModel) Insert(year string, subject *Subject) error {
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&subject.CreatedAt)
 }
 
-func (m SubjectModel) GetAll(year, stage string) ([]*Subject, error) {
+// GetAll lists the subjects of the stage that match the filters, a page at a time.
+// Name matches the Arabic or the English subject name.
+func (m SubjectModel) GetAll(year, stage string, filters Filters) ([]*Subject, Metadata, error) {
 	tableName := fmt.Sprintf("subjects_%s", year)
-	query := fmt.Sprintf("SELECT * FROM %s", tableName)
+	query := fmt.Sprintf("SELECT count(*) OVER(), * FROM %s WHERE 1 = 1", tableName)
 	var args []interface{}
 	if stage != "all" {
-		query += " WHERE stage = $1"
 		args = append(args, stage)
+		query += fmt.Sprintf(" AND stage = $%d", len(args))
 	}
+	columns := filterColumns{
+		name:       []string{"subject_name", "subject_name_english"},
+		department: "department",
+		semester:   "semester",
+	}
+	conditions, args := filters.conditions(columns, args)
+	query += conditions + filters.orderBy("subject_id")
+	limit, args := filters.limitOffset(args)
+	query += limit
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close() // Ensure rows are closed after function returns
 
 	// Slice to hold the movies
+	totalRecords := 0
 	var subjects []*Subject
 
 	// Iterate over rows
 	for rows.Next() {
 		var subject Subject
 		err := rows.Scan(
+			&totalRecords,
 			&subject.ID,
 			&subject.SubjectName,
 			&subject.SubjectNameEnglish,
@@ -136,17 +149,17 @@ func (m SubjectModel) GetAll(year, stage string) ([]*Subject, error) {
 			&subject.CreatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		subjects = append(subjects, &subject)
 	}
 
 	// Check for any iteration errors
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return subjects, nil
+	return subjects, calculateMetadata(totalRecords, filters), nil
 }
 
 // Add a placeholder method for fetching a specific record from the movies table.
@@ -269,3 +282,91 @@ func (m SubjectModel) Delete(year string, id int64) error {
 	}
 	return nil
 }
+
+// SubjectCopy describes one subject considered by copySubjects.
+type SubjectCopy struct {
+	ID          int    `json:"subject_id"`
+	SubjectName string `json:"subject_name"`
+	Stage       string `json:"stage"`
+}
+
+// SubjectCopyReport is the diff produced when cloning a subject catalogue.
+type SubjectCopyReport struct {
+	From             string         `json:"from"`
+	To               string         `json:"to"`
+	Copied           []*SubjectCopy `json:"copied"`
+	Skipped          []*SubjectCopy `json:"skipped"`
+	PrivilegesCopied int64          `json:"privileges_copied"`
+}
+
+// copySubjects clones every subject of the from year into the to year, within tx.
+// Subjects whose id already exists in the to year are kept as they are and listed as
+// skipped.
+func copySubjects(ctx context.Context, tx *sql.Tx, from, to string) (*SubjectCopyReport, error) {
+	if strings.TrimSpace(from) == "" || strings.TrimSpace(to) == "" {
+		return nil, errors.New("invalid year")
+	}
+	fromTable := fmt.Sprintf("subjects_%s", from)
+	toTable := fmt.Sprintf("subjects_%s", to)
+	columns := `subject_id, subject_name, subject_name_english, stage, semester, department,
+	max_theory_mark, max_lab_mark, max_semester_mark, max_final_exam, credits, active, ministerial`
+	query := fmt.Sprintf(`
+	INSERT INTO %s (%s)
+	SELECT %s FROM %s
+	ON CONFLICT (subject_id) DO NOTHING
+	RETURNING subject_id`, toTable, columns, columns, fromTable)
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+	SELECT subject_id, subject_name, stage FROM %s ORDER BY subject_id`, fromTable))
+	if err != nil {
+		return nil, err
+	}
+	var source []*SubjectCopy
+	for rows.Next() {
+		var subject SubjectCopy
+		err = rows.Scan(&subject.ID, &subject.SubjectName, &subject.Stage)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		source = append(source, &subject)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	copied := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		copied[id] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &SubjectCopyReport{
+		From:    from,
+		To:      to,
+		Copied:  []*SubjectCopy{},
+		Skipped: []*SubjectCopy{},
+	}
+	for _, subject := range source {
+		if copied[subject.ID] {
+			report.Copied = append(report.Copied, subject)
+		} else {
+			report.Skipped = append(report.Skipped, subject)
+		}
+	}
+	return report, nil
+}
@@ -12,13 +12,31 @@ type Table struct {
 	TableName string `json:"table_name"`
 }
 
+// GlobalTables are the tables shared by every academic year. Privileges on them are
+// stored with year and stage "all".
+var GlobalTables = []string{"users", "privileges", "years", "audit", "locks", "roles"}
+
+// YearTables are the tables created for every academic year, named <table>_<year>.
+// Tables come after the tables they reference, archives are restored in this order.
+var YearTables = []string{"students", "subjects", "carryovers", "exempted", "marks", "attendance", "exams"}
+
+// IsGlobalTable reports whether name is one of GlobalTables.
+func IsGlobalTable(name string) bool {
+	for _, table := range GlobalTables {
+		if name == table {
+			return true
+		}
+	}
+	return false
+}
+
 type TableModel struct {
 	DB *sql.DB
 }
 
 func (t TableModel) GetByName(name, year string) (*Table, error) {
 	var tableName string
-	if name == "users" || name == "privileges" || name == "years" {
+	if IsGlobalTable(name) {
 		tableName = name
 	} else {
 		tableName = name + "_" + year
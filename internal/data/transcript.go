@@ -0,0 +1,224 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Transcript (وثيقة) is a student's record across every academic year they were
+// enrolled in.
+type Transcript struct {
+	StudentId   int64             `json:"student_id"`
+	StudentName string            `json:"student_name"`
+	State       string            `json:"state"`
+	Years       []*TranscriptYear `json:"years"`
+	// Credits counts the credits of passed subjects, Average is the credit-weighted
+	// average of every counted subject of every year.
+	Credits int     `json:"credits"`
+	Average float64 `json:"average"`
+}
+
+// TranscriptYear is what one academic year contributes to a transcript.
+type TranscriptYear struct {
+	Year     string               `json:"year"`
+	Stage    string               `json:"stage"`
+	State    string               `json:"state"`
+	Average  float64              `json:"average"`
+	Credits  int                  `json:"credits"`
+	Failed   int                  `json:"failed"`
+	Subjects []*TranscriptSubject `json:"subjects"`
+}
+
+type TranscriptSubject struct {
+	SubjectId          int64   `json:"subject_id"`
+	SubjectName        string  `json:"subject_name"`
+	SubjectNameEnglish string  `json:"subject_name_english"`
+	Stage              string  `json:"stage"`
+	Semester           string  `json:"semester"`
+	Credits            int     `json:"credits"`
+	Carryover          bool    `json:"carryover"`
+	Exempted           bool    `json:"exempted"`
+	Round              int     `json:"round"`
+	Total              int     `json:"total"`
+	Percentage         float64 `json:"percentage"`
+	Passed             bool    `json:"passed"`
+	Grade              string  `json:"grade"`
+}
+
+type TranscriptModel struct {
+	DB *sql.DB
+}
+
+// Get builds the student's transcript from every year listed in the years table. It
+// returns ErrRecordNotFound when the student isn't in any year. Exempted subjects are
+// listed but not counted, and subjects without a mark aren't listed.
+func (m TranscriptModel) Get(studentId int64, policy GradePolicy) (*Transcript, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	enrolments, err := studentYears(ctx, m.DB, studentId)
+	if err != nil {
+		return nil, err
+	}
+	if len(enrolments) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	transcript := &Transcript{StudentId: studentId, Years: []*TranscriptYear{}}
+	var weighted float64
+	var counted int
+	for _, student := range enrolments {
+		transcript.StudentName = student.StudentName
+		transcript.State = student.State
+		year, err := transcriptYear(ctx, m.DB, student, policy)
+		if err != nil {
+			return nil, err
+		}
+		transcript.Years = append(transcript.Years, year)
+		for _, subject := range year.Subjects {
+			if subject.Exempted {
+				continue
+			}
+			weighted += subject.Percentage * float64(subject.Credits)
+			counted += subject.Credits
+			if subject.Passed {
+				transcript.Credits += subject.Credits
+			}
+		}
+	}
+	if counted > 0 {
+		transcript.Average = math.Round(weighted*100/float64(counted)) / 100
+	}
+	return transcript, nil
+}
+
+// studentYears finds the student in the students table of every year, oldest year
+// first. Each returned Student has its Year set.
+func studentYears(ctx context.Context, db *sql.DB, studentId int64) ([]*Student, error) {
+	rows, err := db.QueryContext(ctx, `SELECT year FROM years ORDER BY year`)
+	if err != nil {
+		return nil, err
+	}
+	var years []string
+	for rows.Next() {
+		var year string
+		if err := rows.Scan(&year); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		years = append(years, year)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var students []*Student
+	for _, year := range years {
+		if !isValidAcademicYear(year) {
+			continue
+		}
+		query := fmt.Sprintf(`
+		SELECT seq_in_college, student_name, stage, student_id, state
+		FROM students_%s
+		WHERE student_id = $1`, year)
+		student := Student{Year: year}
+		err := db.QueryRowContext(ctx, query, studentId).Scan(
+			&student.SeqInCollege,
+			&student.StudentName,
+			&student.Stage,
+			&student.StudentId,
+			&student.State,
+		)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return nil, err
+		}
+		students = append(students, &student)
+	}
+	return students, nil
+}
+
+// transcriptYear collects the subjects the student has a mark or an exemption in for
+// the student's year.
+func transcriptYear(ctx context.Context, db *sql.DB, student *Student, policy GradePolicy) (*TranscriptYear, error) {
+	year := student.Year
+	query := fmt.Sprintf(`
+	SELECT
+	sub.subject_id, sub.subject_name, sub.subject_name_english, sub.stage, sub.semester, sub.credits,
+	sub.max_semester_mark, sub.max_final_exam,
+	m.semester_mark, m.final_mark, m.second_final_mark,
+	m.id IS NOT NULL, e.id IS NOT NULL, c.id IS NOT NULL
+	FROM subjects_%[1]s sub
+	LEFT JOIN marks_%[1]s m ON m.subject_id = sub.subject_id AND m.student_id = $1
+	LEFT JOIN exempted_%[1]s e ON e.subject_id = sub.subject_id AND e.student_id = $1
+	LEFT JOIN carryovers_%[1]s c ON c.subject_id = sub.subject_id AND c.student_id = $1
+	WHERE m.id IS NOT NULL OR e.id IS NOT NULL
+	ORDER BY sub.semester, sub.subject_id`, year)
+	rows, err := db.QueryContext(ctx, query, student.StudentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &TranscriptYear{
+		Year:     year,
+		Stage:    student.Stage,
+		State:    student.State,
+		Subjects: []*TranscriptSubject{},
+	}
+	average := &StudentAverage{}
+	for rows.Next() {
+		var subject TranscriptSubject
+		var mark Mark
+		var semester, final sql.NullInt64
+		var marked bool
+		err := rows.Scan(
+			&subject.SubjectId,
+			&subject.SubjectName,
+			&subject.SubjectNameEnglish,
+			&subject.Stage,
+			&subject.Semester,
+			&subject.Credits,
+			&mark.MaxSemesterMark,
+			&mark.MaxFinalExam,
+			&semester,
+			&final,
+			&mark.SecondFinalMark,
+			&marked,
+			&subject.Exempted,
+			&subject.Carryover,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if marked && !subject.Exempted {
+			mark.SemesterMark = int(semester.Int64)
+			mark.FinalMark = int(final.Int64)
+			policy.Grade(&mark)
+			subject.Round = mark.Round
+			subject.Total = mark.Total
+			subject.Percentage = mark.Percentage
+			subject.Passed = mark.Passed
+			subject.Grade = mark.Grade
+			average.Subjects = append(average.Subjects, &SubjectResult{
+				Credits:    subject.Credits,
+				Percentage: subject.Percentage,
+				Passed:     subject.Passed,
+			})
+		}
+		result.Subjects = append(result.Subjects, &subject)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	average.compute()
+	result.Average = average.Average
+	result.Credits = average.Credits
+	result.Failed = average.Failed
+	return result, nil
+}
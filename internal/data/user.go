@@ -2,13 +2,19 @@ package data
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"collegecm.hamid.net/internal/validator"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// passwordCost is the bcrypt work factor used for every new password hash.
+const passwordCost = 12
+
 type User struct {
 	ID        int64     `json:"id"`
 	Username  string    `json:"username"`
@@ -19,7 +25,56 @@ type User struct {
 func ValidateUser(v *validator.Validator, user *User) {
 	// TODO - handle strings length with varchar
 	v.Check(user.Username != "", "اسم الحساب", "يجب تزويد المعلومات")
-	v.Check(user.Password != "", "الرمز", "يجب تزويد المعلومات")
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "الرمز", "يجب تزويد المعلومات")
+	// bcrypt silently ignores everything after the 72nd byte.
+	v.Check(len(password) <= 72, "الرمز", "يجب ان لا يتجاوز 72 حرف")
+}
+
+// SetPassword hashes the plaintext password and stores the hash on the user. Only the
+// hash is ever written to the users table.
+func (user *User) SetPassword(plaintext string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), passwordCost)
+	if err != nil {
+		return err
+	}
+	user.Password = string(hash)
+	return nil
+}
+
+// PasswordMatches reports whether the plaintext password is the user's password. Rows
+// created before passwords were hashed still hold the plaintext, so those are compared
+// directly; NeedsRehash tells the caller to upgrade them.
+func (user *User) PasswordMatches(plaintext string) (bool, error) {
+	if user.NeedsRehash() {
+		return subtle.ConstantTimeCompare([]byte(user.Password), []byte(plaintext)) == 1, nil
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// NeedsRehash reports whether the stored password is still a legacy plaintext value.
+func (user *User) NeedsRehash() bool {
+	return !isPasswordHash(user.Password)
+}
+
+func isPasswordHash(password string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(password, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 type UserModel struct {
@@ -116,6 +171,20 @@ func (u UserModel) Update(user *User) error {
 	return err
 }
 
+func (u UserModel) UpdatePassword(user *User) error {
+	if user.ID < 0 {
+		return ErrRecordNotFound
+	}
+	query := `
+	UPDATE users
+	SET password = $1
+	WHERE id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := u.DB.ExecContext(ctx, query, user.Password, user.ID)
+	return err
+}
+
 func (u UserModel) Delete(id int64) error {
 	if id < 0 {
 		return ErrRecordNotFound
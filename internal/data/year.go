@@ -3,17 +3,19 @@ package data
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"collegecm.hamid.net/internal/validator"
+	"github.com/lib/pq"
 )
 
 type Year struct {
 	Year string `json:"year"`
+	// ArchivedAt is set while the year is archived, which makes it read-only.
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
 }
 
 func ValidateYear(v *validator.Validator, year *Year) {
@@ -44,7 +46,7 @@ type YearModel struct {
 }
 
 func (y YearModel) GetAll() ([]*Year, error) {
-	q := `SELECT * FROM years;`
+	q := `SELECT year, archived_at FROM years ORDER BY year;`
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	rows, err := y.DB.QueryContext(ctx, q)
@@ -58,6 +60,7 @@ func (y YearModel) GetAll() ([]*Year, error) {
 		var year Year
 		err := rows.Scan(
 			&year.Year,
+			&year.ArchivedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -70,186 +73,162 @@ func (y YearModel) GetAll() ([]*Year, error) {
 	return years, nil
 }
 
-func (y YearModel) Insert(year *Year) error {
-	studentsTablename := fmt.Sprintf("students_%s", year.Year)
-	subjectsTablename := fmt.Sprintf("subjects_%s", year.Year)
-	carryoverTablename := fmt.Sprintf("carryovers_%s", year.Year)
-	exemptedTablename := fmt.Sprintf("exempted_%s", year.Year)
-	marksTablename := fmt.Sprintf("marks_%s", year.Year)
-
-	studentsQ := fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-	seq_in_college SERIAL,
-    student_name VARCHAR(255) NOT NULL,
-    stage VARCHAR(100) NOT NULL,
-    student_id INTEGER NOT NULL PRIMARY KEY,
-    state VARCHAR(100) NOT NULL,
-    created_at timestamp(0) with time zone NOT NULL DEFAULT now()
-	);`, studentsTablename)
-	subjectsQ := fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-	subject_id           INTEGER NOT NULL PRIMARY KEY 
-  	,subject_name         VARCHAR(100) NOT NULL
-  	,subject_name_english VARCHAR(100) NOT NULL
-  	,stage                VARCHAR(30) NOT NULL
-  	,semester             VARCHAR(30) NOT NULL
-  	,department           VARCHAR(100) NOT NULL
-  	,max_theory_mark      INTEGER  NOT NULL
-  	,max_lab_mark         INTEGER  NOT NULL
-  	,max_semester_mark    INTEGER  NOT NULL
-  	,max_final_exam       INTEGER  NOT NULL
-  	,credits              INTEGER  NOT NULL
-  	,active               VARCHAR(10) NOT NULL
-  	,ministerial          VARCHAR(10) NOT NULL
-  	,created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
-	);`, subjectsTablename)
-	carryoverQ := fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-	id SERIAL PRIMARY KEY,
-    student_id INTEGER REFERENCES %s(student_id) ON DELETE CASCADE NOT NULL,
-    subject_id INTEGER REFERENCES %s(subject_id) ON DELETE CASCADE NOT NULL,
-    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    UNIQUE (student_id, subject_id)
-	);`, carryoverTablename, studentsTablename, subjectsTablename)
-	exemptedQ := fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-	id SERIAL PRIMARY KEY,
-    student_id INTEGER REFERENCES %s(student_id) ON DELETE CASCADE NOT NULL ,
-    subject_id INTEGER REFERENCES %s(subject_id) ON DELETE CASCADE NOT NULL ,
-    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    UNIQUE (student_id, subject_id)
-	);`, exemptedTablename, studentsTablename, subjectsTablename)
-	marksQ := fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-	id SERIAL PRIMARY KEY,
-    student_id INTEGER REFERENCES %s(student_id) ON DELETE CASCADE NOT NULL,
-    subject_id INTEGER REFERENCES %s(subject_id) ON DELETE CASCADE NOT NULL,
-	semester_mark INTEGER NOT NULL DEFAULT 0,
-	final_mark INTEGER NOT NULL DEFAULT 0,
-    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    UNIQUE (student_id, subject_id)
-	);`, marksTablename, studentsTablename, subjectsTablename)
-	q2 := `INSERT INTO tables (table_name) values ($1);`
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Exists reports whether the year is listed in the years table.
+func (y YearModel) Exists(year string) (bool, error) {
+	q := `SELECT EXISTS (SELECT 1 FROM years WHERE year = $1);`
+	var exists bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := createStudentsTable(ctx, y.DB, studentsQ, q2, studentsTablename)
-	if err != nil {
-		return err
-	}
-	err = createSubjectsTable(ctx, y.DB, subjectsQ, q2, subjectsTablename)
-	if err != nil {
-		return err
+	err := y.DB.QueryRowContext(ctx, q, year).Scan(&exists)
+	return exists, err
+}
+
+// IsArchived reports whether the year is archived. A year that isn't listed in the
+// years table isn't archived.
+func (y YearModel) IsArchived(year string) (bool, error) {
+	q := `SELECT EXISTS (SELECT 1 FROM years WHERE year = $1 AND archived_at IS NOT NULL);`
+	var archived bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := y.DB.QueryRowContext(ctx, q, year).Scan(&archived)
+	return archived, err
+}
+
+// SetArchived archives the year, or makes it writable again. It returns
+// ErrRecordNotFound when the year doesn't exist.
+func (y YearModel) SetArchived(year string, archived bool) error {
+	q := `UPDATE years SET archived_at = NULL WHERE year = $1;`
+	if archived {
+		q = `UPDATE years SET archived_at = COALESCE(archived_at, NOW()) WHERE year = $1;`
 	}
-	err = createCarryoversTable(ctx, y.DB, carryoverQ, q2, carryoverTablename)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := y.DB.ExecContext(ctx, q, year)
 	if err != nil {
 		return err
 	}
-	err = createExemptedTable(ctx, y.DB, exemptedQ, q2, exemptedTablename)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-	err = createMarksTable(ctx, y.DB, marksQ, q2, marksTablename)
-	if err != nil {
-		return err
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
 	}
+	return nil
+}
 
-	q := `INSERT INTO years (year) VALUES ($1);`
-	args := []interface{}{
-		year.Year,
-	}
-	_, err = y.DB.ExecContext(ctx, q, args...)
-	return err
+// YearCopy asks Insert to clone the subject catalogue of the From year into the new
+// year and, with Privileges, the privileges on From's tables.
+type YearCopy struct {
+	From       string
+	Privileges bool
 }
 
-func createStudentsTable(ctx context.Context, db *sql.DB, query, q2, table string) error {
-	_, err := db.ExecContext(ctx, query)
+// Insert provisions the per-year tables by applying the year migrations in
+// migrations/year, records the year and makes the copy when one is given, all in one
+// transaction: a failure leaves nothing behind, and retrying a create that got through
+// is harmless. A copy needs a new year, copying into an existing one returns
+// ErrYearExists. It reports whether the year was newly created.
+func (y YearModel) Insert(year *Year, yearCopy *YearCopy) (bool, *SubjectCopyReport, error) {
+	err := ensureMigrationsTable(y.DB)
 	if err != nil {
-		return err
+		return false, nil, err
 	}
-	args := []interface{}{table}
-	_, err = db.ExecContext(ctx, q2, args...)
-	return err
-}
-
-func createSubjectsTable(ctx context.Context, db *sql.DB, query, q2, table string) error {
-	_, err := db.ExecContext(ctx, query)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tx, err := y.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return false, nil, err
 	}
-	args := []interface{}{table}
-	_, err = db.ExecContext(ctx, q2, args...)
-	return err
-}
-
-func createCarryoversTable(ctx context.Context, db *sql.DB, query, q2, table string) error {
-	_, err := db.ExecContext(ctx, query)
+	defer tx.Rollback()
+	err = lockYear(ctx, tx, year.Year)
 	if err != nil {
-		return err
+		return false, nil, err
 	}
-	args := []interface{}{table}
-	_, err = db.ExecContext(ctx, q2, args...)
-	return err
-}
-
-func createExemptedTable(ctx context.Context, db *sql.DB, query, q2, table string) error {
-	_, err := db.ExecContext(ctx, query)
+	err = provisionYear(ctx, tx, year.Year, false)
 	if err != nil {
-		return err
+		return false, nil, err
 	}
-	args := []interface{}{table}
-	_, err = db.ExecContext(ctx, q2, args...)
-	return err
-}
-
-func createMarksTable(ctx context.Context, db *sql.DB, query, q2, table string) error {
-	_, err := db.ExecContext(ctx, query)
+	q := `INSERT INTO years (year) VALUES ($1) ON CONFLICT (year) DO NOTHING;`
+	result, err := tx.ExecContext(ctx, q, year.Year)
 	if err != nil {
-		return err
+		return false, nil, err
 	}
-	args := []interface{}{table}
-	_, err = db.ExecContext(ctx, q2, args...)
-	return err
+	created, err := result.RowsAffected()
+	if err != nil {
+		return false, nil, err
+	}
+	if yearCopy == nil {
+		return created > 0, nil, tx.Commit()
+	}
+	if created == 0 {
+		return false, nil, ErrYearExists
+	}
+	report, err := copySubjects(ctx, tx, yearCopy.From, year.Year)
+	if err != nil {
+		return false, nil, err
+	}
+	if yearCopy.Privileges {
+		report.PrivilegesCopied, err = copyPrivileges(ctx, tx, yearCopy.From, year.Year)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+	return true, report, tx.Commit()
 }
 
+// Delete drops every per-year table by reverting the year migrations, then removes
+// the year along with its migration history, locks and the privileges and role
+// assignments on it. It runs in one transaction and deleting a year that is already
+// gone succeeds.
 func (y YearModel) Delete(year string) error {
-	studentsTable := fmt.Sprintf("students_%s", year)
-	subjectsTable := fmt.Sprintf("subjects_%s", year)
-	carryoversTable := fmt.Sprintf("carryovers_%s", year)
-	exemptedTable := fmt.Sprintf("exempted_%s", year)
-	marksTable := fmt.Sprintf("marks_%s", year)
-	stq := fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, studentsTable)
-	suq := fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, subjectsTable)
-	cq := fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, carryoversTable)
-	eq := fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, exemptedTable)
-	mq := fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, marksTable)
-	q := `DELETE FROM tables WHERE table_name LIKE $1;`
-	q2 := `DELETE FROM years WHERE year = $1;`
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_, err := y.DB.ExecContext(ctx, mq)
+	err := ensureMigrationsTable(y.DB)
 	if err != nil {
 		return err
 	}
-	_, err = y.DB.ExecContext(ctx, eq)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tx, err := y.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = y.DB.ExecContext(ctx, cq)
+	defer tx.Rollback()
+	err = lockYear(ctx, tx, year)
 	if err != nil {
 		return err
 	}
-	_, err = y.DB.ExecContext(ctx, stq)
-	if err != nil {
-		return err
+	// privileges point at the tables rows, which the down migrations remove.
+	var tableNames []string
+	for _, table := range YearTables {
+		tableNames = append(tableNames, table+"_"+year)
 	}
-	_, err = y.DB.ExecContext(ctx, suq)
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM privileges
+	WHERE table_id IN (SELECT id FROM tables WHERE table_name = ANY($1))`, pq.Array(tableNames))
 	if err != nil {
 		return err
 	}
-	_, err = y.DB.ExecContext(ctx, q, "%"+year)
+	err = teardownYear(ctx, tx, year)
 	if err != nil {
 		return err
 	}
-	_, err = y.DB.ExecContext(ctx, q2, year)
+	for _, q := range []string{
+		`DELETE FROM schema_migrations WHERE scope = $1;`,
+		`DELETE FROM locks WHERE year = $1;`,
+		`DELETE FROM user_roles WHERE year = $1;`,
+		`DELETE FROM years WHERE year = $1;`,
+	} {
+		_, err = tx.ExecContext(ctx, q, year)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lockYear serialises provisioning and teardown of the year for the rest of tx, so
+// concurrent requests for the same year queue up instead of interleaving their DDL.
+func lockYear(ctx context.Context, tx *sql.Tx, year string) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('year:' || $1))`, year)
 	return err
 }
@@ -0,0 +1,142 @@
+// Package pdf writes simple text documents as PDF without any dependency. It only
+// knows the standard Helvetica fonts, so text is limited to the Latin-1 characters;
+// anything else (Arabic included) is printed as '?'.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size in points.
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+type Document struct {
+	Title string
+	pages []*Page
+}
+
+// Page holds the content stream of one page. Coordinates are in points from the
+// bottom left corner of the page, as in PDF itself.
+type Page struct {
+	content bytes.Buffer
+}
+
+func New(title string) *Document {
+	return &Document{Title: title}
+}
+
+// AddPage appends a blank A4 page and returns it.
+func (d *Document) AddPage() *Page {
+	page := &Page{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Text draws s with its baseline starting at (x, y).
+func (p *Page) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+// Line draws a thin line from (x1, y1) to (x2, y2).
+func (p *Page) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// Encodable reports whether every character of s can be printed.
+func Encodable(s string) bool {
+	for _, r := range s {
+		if r > 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// escape encodes s as Latin-1 and escapes it for a PDF string literal.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r < 0x20 || r > 0xff:
+			b.WriteByte('?')
+		case r < 0x80:
+			b.WriteByte(byte(r))
+		default:
+			fmt.Fprintf(&b, "\\%03o", r)
+		}
+	}
+	return b.String()
+}
+
+// WriteTo writes the whole document. A document without pages gets one blank page.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	var offsets []int64
+	object := func(body string) {
+		offsets = append(offsets, cw.n)
+		fmt.Fprintf(cw, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	fmt.Fprint(cw, "%PDF-1.4\n")
+	// objects 1 to 5 are fixed, each page then takes a page and a content object.
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 6+2*i))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (collegecm) >>", escape(d.Title)))
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", PageWidth, PageHeight, 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+
+	xref := cw.n
+	fmt.Fprintf(cw, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(cw, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(cw, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// countingWriter tracks the byte offsets the cross-reference table needs and keeps the
+// first write error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
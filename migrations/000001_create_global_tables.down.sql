@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS privileges;
+DROP TABLE IF EXISTS years;
+DROP TABLE IF EXISTS tables;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(255) NOT NULL UNIQUE,
+    password VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS tables (
+    id SERIAL PRIMARY KEY,
+    table_name VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS years (
+    year VARCHAR(20) NOT NULL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS privileges (
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    year VARCHAR(20) NOT NULL,
+    table_id INTEGER NOT NULL,
+    stage VARCHAR(100) NOT NULL,
+    subject_id INTEGER NOT NULL DEFAULT -1,
+    can_read BOOLEAN NOT NULL DEFAULT FALSE,
+    can_write BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, year, table_id, stage, subject_id)
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    token TEXT PRIMARY KEY,
+    data BYTEA NOT NULL,
+    expiry TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON sessions (expiry);
+
+INSERT INTO tables (table_name)
+SELECT name FROM (VALUES ('users'), ('privileges'), ('years')) AS global(name)
+WHERE NOT EXISTS (SELECT 1 FROM tables WHERE table_name = global.name);
@@ -0,0 +1,4 @@
+DELETE FROM privileges WHERE table_id IN (SELECT id FROM tables WHERE table_name = 'audit');
+DELETE FROM tables WHERE table_name = 'audit';
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    year VARCHAR(20) NOT NULL DEFAULT '',
+    table_name VARCHAR(100) NOT NULL,
+    record_id BIGINT NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    before JSONB,
+    after JSONB,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_record_idx ON audit_log (table_name, record_id);
+CREATE INDEX IF NOT EXISTS audit_log_user_idx ON audit_log (user_id);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+
+-- the trail is append-only, rows can be added but never changed or removed.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+INSERT INTO tables (table_name)
+SELECT 'audit'
+WHERE NOT EXISTS (SELECT 1 FROM tables WHERE table_name = 'audit');
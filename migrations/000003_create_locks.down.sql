@@ -0,0 +1,3 @@
+DELETE FROM privileges WHERE table_id IN (SELECT id FROM tables WHERE table_name = 'locks');
+DELETE FROM tables WHERE table_name = 'locks';
+DROP TABLE IF EXISTS locks;
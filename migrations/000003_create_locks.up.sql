@@ -0,0 +1,14 @@
+-- subject_id -1 locks every subject of the stage.
+CREATE TABLE IF NOT EXISTS locks (
+    id BIGSERIAL PRIMARY KEY,
+    year VARCHAR(20) NOT NULL,
+    stage VARCHAR(50) NOT NULL,
+    subject_id INTEGER NOT NULL DEFAULT -1,
+    locked_by INTEGER NOT NULL,
+    locked_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (year, stage, subject_id)
+);
+
+INSERT INTO tables (table_name)
+SELECT 'locks'
+WHERE NOT EXISTS (SELECT 1 FROM tables WHERE table_name = 'locks');
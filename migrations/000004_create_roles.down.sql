@@ -0,0 +1,6 @@
+DROP VIEW IF EXISTS effective_privileges;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_grants;
+DROP TABLE IF EXISTS roles;
+DELETE FROM privileges WHERE table_id IN (SELECT id FROM tables WHERE table_name = 'roles');
+DELETE FROM tables WHERE table_name = 'roles';
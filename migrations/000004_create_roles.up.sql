@@ -0,0 +1,79 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- table_name is a year table without its year suffix (students, marks, ...) or a
+-- global table. An empty stage takes the stage of the assignment.
+CREATE TABLE IF NOT EXISTS role_grants (
+    role_id INTEGER REFERENCES roles(id) ON DELETE CASCADE NOT NULL,
+    table_name VARCHAR(100) NOT NULL,
+    stage VARCHAR(100) NOT NULL DEFAULT '',
+    can_read BOOLEAN NOT NULL DEFAULT FALSE,
+    can_write BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE (role_id, table_name, stage)
+);
+
+-- year and stage may be 'all'; subject_id only narrows down marks grants.
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    role_id INTEGER REFERENCES roles(id) ON DELETE CASCADE NOT NULL,
+    year VARCHAR(20) NOT NULL,
+    stage VARCHAR(100) NOT NULL,
+    subject_id INTEGER NOT NULL DEFAULT -1,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, role_id, year, stage, subject_id)
+);
+
+-- every grant a user holds, directly or through a role.
+CREATE OR REPLACE VIEW effective_privileges AS
+SELECT p.user_id, t.table_name, p.stage, p.subject_id, p.can_read, p.can_write
+FROM privileges p
+JOIN tables t ON p.table_id = t.id
+UNION ALL
+SELECT ur.user_id, t.table_name,
+    CASE WHEN rg.stage <> '' THEN rg.stage ELSE ur.stage END,
+    CASE WHEN rg.table_name = 'marks' THEN ur.subject_id ELSE -1 END,
+    rg.can_read, rg.can_write
+FROM user_roles ur
+JOIN role_grants rg ON rg.role_id = ur.role_id
+JOIN tables t ON t.table_name = rg.table_name
+    OR t.table_name = rg.table_name || '_' || ur.year
+    OR (ur.year = 'all' AND t.table_name LIKE rg.table_name || '\_%');
+
+INSERT INTO tables (table_name)
+SELECT 'roles'
+WHERE NOT EXISTS (SELECT 1 FROM tables WHERE table_name = 'roles');
+
+INSERT INTO roles (name, description)
+VALUES
+    ('registrar', 'المسجل: ادارة الطلبة والمواد والتحميل والاعفاء وقراءة الدرجات'),
+    ('coordinator', 'منسق المرحلة: ادارة جميع جداول المرحلة'),
+    ('lecturer', 'التدريسي: ادخال درجات المواد المسندة اليه'),
+    ('viewer', 'مشاهد: قراءة جميع جداول السنة')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_grants (role_id, table_name, can_read, can_write)
+SELECT r.id, g.table_name, g.can_read, g.can_write
+FROM roles r
+JOIN (VALUES
+    ('registrar', 'students', TRUE, TRUE),
+    ('registrar', 'subjects', TRUE, TRUE),
+    ('registrar', 'carryovers', TRUE, TRUE),
+    ('registrar', 'exempted', TRUE, TRUE),
+    ('registrar', 'marks', TRUE, FALSE),
+    ('coordinator', 'students', TRUE, TRUE),
+    ('coordinator', 'subjects', TRUE, TRUE),
+    ('coordinator', 'carryovers', TRUE, TRUE),
+    ('coordinator', 'exempted', TRUE, TRUE),
+    ('coordinator', 'marks', TRUE, TRUE),
+    ('lecturer', 'marks', TRUE, TRUE),
+    ('viewer', 'students', TRUE, FALSE),
+    ('viewer', 'subjects', TRUE, FALSE),
+    ('viewer', 'carryovers', TRUE, FALSE),
+    ('viewer', 'exempted', TRUE, FALSE),
+    ('viewer', 'marks', TRUE, FALSE)
+) AS g(role_name, table_name, can_read, can_write) ON r.name = g.role_name
+ON CONFLICT (role_id, table_name, stage) DO NOTHING;
@@ -0,0 +1 @@
+ALTER TABLE years DROP COLUMN IF EXISTS archived_at;
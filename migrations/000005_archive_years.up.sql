@@ -0,0 +1,2 @@
+-- an archived year is read-only, its tables are kept as they are.
+ALTER TABLE years ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP(0) WITH TIME ZONE;
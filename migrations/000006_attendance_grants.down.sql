@@ -0,0 +1,16 @@
+DELETE FROM role_grants WHERE table_name = 'attendance';
+
+CREATE OR REPLACE VIEW effective_privileges AS
+SELECT p.user_id, t.table_name, p.stage, p.subject_id, p.can_read, p.can_write
+FROM privileges p
+JOIN tables t ON p.table_id = t.id
+UNION ALL
+SELECT ur.user_id, t.table_name,
+    CASE WHEN rg.stage <> '' THEN rg.stage ELSE ur.stage END,
+    CASE WHEN rg.table_name = 'marks' THEN ur.subject_id ELSE -1 END,
+    rg.can_read, rg.can_write
+FROM user_roles ur
+JOIN role_grants rg ON rg.role_id = ur.role_id
+JOIN tables t ON t.table_name = rg.table_name
+    OR t.table_name = rg.table_name || '_' || ur.year
+    OR (ur.year = 'all' AND t.table_name LIKE rg.table_name || '\_%');
@@ -0,0 +1 @@
+DELETE FROM role_grants WHERE table_name = 'exams';
@@ -0,0 +1,12 @@
+-- the registrar and the stage coordinators plan the exam timetable, everyone else
+-- reads it.
+INSERT INTO role_grants (role_id, table_name, can_read, can_write)
+SELECT r.id, 'exams', g.can_read, g.can_write
+FROM roles r
+JOIN (VALUES
+    ('registrar', TRUE, TRUE),
+    ('coordinator', TRUE, TRUE),
+    ('lecturer', TRUE, FALSE),
+    ('viewer', TRUE, FALSE)
+) AS g(role_name, can_read, can_write) ON r.name = g.role_name
+ON CONFLICT (role_id, table_name, stage) DO NOTHING;
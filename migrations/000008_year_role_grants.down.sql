@@ -0,0 +1,2 @@
+-- the removed global grants aren't restored, they have to be given as privileges.
+SELECT 1;
@@ -0,0 +1,4 @@
+-- roles may only grant the year tables; the global tables (users, privileges, roles,
+-- audit, locks) are granted per user, so writing roles can't be turned into admin.
+DELETE FROM role_grants
+WHERE table_name IN ('users', 'privileges', 'years', 'audit', 'locks', 'roles');
@@ -0,0 +1,13 @@
+// Package migrations embeds the SQL schema migrations so the binaries can apply them
+// without shipping this directory alongside.
+//
+// Files in this directory are applied once against the database. Files under year/
+// are templates that are applied once for every academic year listed in the years
+// table, with {{.Year}} replaced by the year suffix (e.g. 2024_2025). Every file is
+// named NNNNNN_description.up.sql or NNNNNN_description.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql year/*.sql
+var FS embed.FS
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS marks_{{.Year}};
+DROP TABLE IF EXISTS exempted_{{.Year}};
+DROP TABLE IF EXISTS carryovers_{{.Year}};
+DROP TABLE IF EXISTS students_{{.Year}};
+DROP TABLE IF EXISTS subjects_{{.Year}};
+
+DELETE FROM tables WHERE table_name IN (
+    'students_{{.Year}}',
+    'subjects_{{.Year}}',
+    'carryovers_{{.Year}}',
+    'exempted_{{.Year}}',
+    'marks_{{.Year}}'
+);
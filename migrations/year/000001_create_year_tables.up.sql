@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS students_{{.Year}} (
+    seq_in_college SERIAL,
+    student_name VARCHAR(255) NOT NULL,
+    stage VARCHAR(100) NOT NULL,
+    student_id INTEGER NOT NULL PRIMARY KEY,
+    state VARCHAR(100) NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS subjects_{{.Year}} (
+    subject_id           INTEGER NOT NULL PRIMARY KEY
+  ,subject_name         VARCHAR(100) NOT NULL
+  ,subject_name_english VARCHAR(100) NOT NULL
+  ,stage                VARCHAR(30) NOT NULL
+  ,semester             VARCHAR(30) NOT NULL
+  ,department           VARCHAR(100) NOT NULL
+  ,max_theory_mark      INTEGER  NOT NULL
+  ,max_lab_mark         INTEGER  NOT NULL
+  ,max_semester_mark    INTEGER  NOT NULL
+  ,max_final_exam       INTEGER  NOT NULL
+  ,credits              INTEGER  NOT NULL
+  ,active               VARCHAR(10) NOT NULL
+  ,ministerial          VARCHAR(10) NOT NULL
+  ,created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS carryovers_{{.Year}} (
+    id SERIAL PRIMARY KEY,
+    student_id INTEGER REFERENCES students_{{.Year}}(student_id) ON DELETE CASCADE NOT NULL,
+    subject_id INTEGER REFERENCES subjects_{{.Year}}(subject_id) ON DELETE CASCADE NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (student_id, subject_id)
+);
+
+CREATE TABLE IF NOT EXISTS exempted_{{.Year}} (
+    id SERIAL PRIMARY KEY,
+    student_id INTEGER REFERENCES students_{{.Year}}(student_id) ON DELETE CASCADE NOT NULL,
+    subject_id INTEGER REFERENCES subjects_{{.Year}}(subject_id) ON DELETE CASCADE NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (student_id, subject_id)
+);
+
+CREATE TABLE IF NOT EXISTS marks_{{.Year}} (
+    id SERIAL PRIMARY KEY,
+    student_id INTEGER REFERENCES students_{{.Year}}(student_id) ON DELETE CASCADE NOT NULL,
+    subject_id INTEGER REFERENCES subjects_{{.Year}}(subject_id) ON DELETE CASCADE NOT NULL,
+    semester_mark INTEGER NOT NULL DEFAULT 0,
+    final_mark INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (student_id, subject_id)
+);
+
+INSERT INTO tables (table_name)
+SELECT name FROM (VALUES
+    ('students_{{.Year}}'),
+    ('subjects_{{.Year}}'),
+    ('carryovers_{{.Year}}'),
+    ('exempted_{{.Year}}'),
+    ('marks_{{.Year}}')
+) AS year_tables(name)
+WHERE NOT EXISTS (SELECT 1 FROM tables WHERE table_name = year_tables.name);
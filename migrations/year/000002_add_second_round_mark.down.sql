@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS marks_{{.Year}} DROP COLUMN IF EXISTS second_final_mark;
@@ -0,0 +1 @@
+ALTER TABLE marks_{{.Year}} ADD COLUMN IF NOT EXISTS second_final_mark INTEGER;
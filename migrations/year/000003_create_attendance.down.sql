@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS attendance_{{.Year}};
+DELETE FROM privileges WHERE table_id IN (SELECT id FROM tables WHERE table_name = 'attendance_{{.Year}}');
+DELETE FROM tables WHERE table_name = 'attendance_{{.Year}}';
@@ -0,0 +1,18 @@
+-- one row per student for every lecture recorded in a subject; period tells apart the
+-- lectures given on the same day.
+CREATE TABLE IF NOT EXISTS attendance_{{.Year}} (
+    id SERIAL PRIMARY KEY,
+    student_id INTEGER REFERENCES students_{{.Year}}(student_id) ON DELETE CASCADE NOT NULL,
+    subject_id INTEGER REFERENCES subjects_{{.Year}}(subject_id) ON DELETE CASCADE NOT NULL,
+    session_date DATE NOT NULL,
+    period INTEGER NOT NULL DEFAULT 1,
+    absent BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (student_id, subject_id, session_date, period)
+);
+
+CREATE INDEX IF NOT EXISTS attendance_{{.Year}}_subject_idx ON attendance_{{.Year}} (subject_id, session_date, period);
+
+INSERT INTO tables (table_name)
+SELECT 'attendance_{{.Year}}'
+WHERE NOT EXISTS (SELECT 1 FROM tables WHERE table_name = 'attendance_{{.Year}}');
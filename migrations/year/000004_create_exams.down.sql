@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS exams_{{.Year}};
+DELETE FROM privileges WHERE table_id IN (SELECT id FROM tables WHERE table_name = 'exams_{{.Year}}');
+DELETE FROM tables WHERE table_name = 'exams_{{.Year}}';
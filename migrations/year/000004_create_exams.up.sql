@@ -0,0 +1,19 @@
+-- the final exam timetable, one exam per subject and semester.
+CREATE TABLE IF NOT EXISTS exams_{{.Year}} (
+    id SERIAL PRIMARY KEY,
+    subject_id INTEGER REFERENCES subjects_{{.Year}}(subject_id) ON DELETE CASCADE NOT NULL,
+    semester VARCHAR(30) NOT NULL,
+    exam_date DATE NOT NULL,
+    start_time TIME NOT NULL,
+    end_time TIME NOT NULL,
+    room VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (subject_id, semester),
+    CHECK (start_time < end_time)
+);
+
+CREATE INDEX IF NOT EXISTS exams_{{.Year}}_date_idx ON exams_{{.Year}} (exam_date, start_time);
+
+INSERT INTO tables (table_name)
+SELECT 'exams_{{.Year}}'
+WHERE NOT EXISTS (SELECT 1 FROM tables WHERE table_name = 'exams_{{.Year}}');